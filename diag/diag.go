@@ -0,0 +1,173 @@
+package diag
+
+import (
+	"fmt"
+)
+
+// Span is a region of the source code. Lines and columns start at 1, and
+// the end line and column point at the last character in the span.
+type Span struct {
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+}
+
+// Returns a span going from the start of s to the end of end.
+func (s Span) To(end Span) Span {
+	return Span{Line: s.Line, Column: s.Column, EndLine: end.EndLine, EndColumn: end.EndColumn}
+}
+
+// Kind is the category of an error
+type Kind int
+
+const (
+	SyntaxError Kind = iota
+	NameError
+	TypeError
+	RuntimeError
+	ImportError
+)
+
+func (k Kind) String() string {
+	switch k {
+	case SyntaxError:
+		return "syntax"
+	case NameError:
+		return "name"
+	case TypeError:
+		return "type"
+	case ImportError:
+		return "import"
+	}
+
+	return "runtime"
+}
+
+// Error is the error type used by the interpreter. Errors are declared once
+// with New and then given a message and position with With and At. Errors
+// with the same code match each other with errors.Is, so a raised error can be
+// checked against the declared one.
+//
+// The file is set when the error passes out of the file it was raised in. The
+// hint is an optional suggestion for how to fix the error. Value is the value
+// given to the error statement, if the error was raised by one.
+type Error struct {
+	Code  string
+	Kind  Kind
+	Msg   string
+	File  string
+	Hint  string
+	Span  Span
+	Value interface{}
+}
+
+// Declares a new error. The message can contain format verbs which are filled
+// in by With and At.
+func New(kind Kind, code string, msg string) *Error {
+	return &Error{Code: code, Kind: kind, Msg: msg}
+}
+
+// Returns the message with the line number, and the filename if set.
+func (e *Error) Error() string {
+	if e.Span.Line == 0 {
+		return e.Msg
+	}
+
+	msg := fmt.Sprintf("%s, line %d", e.Msg, e.Span.Line)
+	if e.File != "" {
+		msg = e.File + ": " + msg
+	}
+
+	return msg
+}
+
+// Errors match if they have the same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Returns a copy of the error with the args formatted into the message. The
+// copy has no position and is given one by the first call to Locate.
+func (e *Error) With(args ...interface{}) *Error {
+	c := *e
+	if len(args) > 0 {
+		c.Msg = fmt.Sprintf(e.Msg, args...)
+	}
+
+	return &c
+}
+
+// Returns a copy of the error at the given span with the args formatted into
+// the message.
+func (e *Error) At(span Span, args ...interface{}) *Error {
+	c := e.With(args...)
+	c.Span = span
+	return c
+}
+
+// Gives the error the span if it does not have a position yet. Errors that
+// are not of type *Error are returned as they are.
+func Locate(err error, span Span) error {
+	if e, ok := err.(*Error); ok && e.Span.Line == 0 {
+		return e.At(span)
+	}
+
+	return err
+}
+
+// Returns the candidate closest to name, if any are close enough to be a
+// likely typo. Used for "did you mean" hints.
+func Closest(name string, candidates []string) (closest string, ok bool) {
+	best := len(name)/3 + 1
+	for _, c := range candidates {
+		d := distance(name, c)
+		if c != name && (d < best || (ok && d == best && c < closest)) {
+			closest, best, ok = c, d, true
+		}
+	}
+
+	return closest, ok
+}
+
+// Edit distance between a and b, counting swapped neighbour characters as a
+// single edit (optimal string alignment distance).
+func distance(a string, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,57 @@
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Frame is a single function call in the callstack of an error. The file and
+// position are of the call site. Args are the print formatted argument values.
+type Frame struct {
+	Function string   `json:"function"`
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Args     []string `json:"args"`
+}
+
+type jsonError struct {
+	File      string  `json:"file"`
+	Line      int     `json:"line"`
+	Column    int     `json:"column"`
+	EndLine   int     `json:"endLine"`
+	EndColumn int     `json:"endColumn"`
+	Code      string  `json:"code"`
+	Kind      string  `json:"kind"`
+	Message   string  `json:"message"`
+	Hint      string  `json:"hint,omitempty"`
+	Stack     []Frame `json:"stack"`
+}
+
+// Encodes the error and the callstack as a single line JSON object for tools
+// like editors and CI. Errors that are not of type *Error only have their
+// message set:
+//
+//	{"file":"main.fizz","line":2,"column":7,"endLine":2,"endColumn":12,
+//	 "code":"E242","kind":"name","message":"undefined variable 'lenght'",
+//	 "hint":"did you mean 'length'?","stack":[]}
+//
+// Frames in the stack are innermost first:
+//
+//	{"function":"f","file":"main.fizz","line":5,"column":1,"args":["1"]}
+func JSON(err error, stack []Frame) []byte {
+	j := jsonError{Message: err.Error(), Stack: stack}
+	if j.Stack == nil {
+		j.Stack = []Frame{}
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		j.File, j.Code, j.Kind, j.Message, j.Hint = e.File, e.Code, e.Kind.String(), e.Msg, e.Hint
+		j.Line, j.Column, j.EndLine, j.EndColumn = e.Span.Line, e.Span.Column, e.Span.EndLine, e.Span.EndColumn
+	}
+
+	// Cannot fail as all fields are plain values
+	b, _ := json.Marshal(j)
+	return b
+}
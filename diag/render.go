@@ -0,0 +1,77 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Renders the error as a compiler style diagnostic with the file and position,
+// the source line, and a marker under the span of the error:
+//
+//	error[E242]: undefined variable 'lenght'
+//	 --> main.fizz:4:7
+//	  |
+//	4 | print lenght(arr);
+//	  |       ^^^^^^
+//	  = hint: did you mean 'length'?
+//
+// The source is the full source code of the file the error was raised in. The
+// source line is left out if it cannot be found in the source.
+func Render(e *Error, source string) string {
+	file := e.File
+	if file == "" {
+		file = "input"
+	}
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "error[%s]: %s\n", e.Code, e.Msg)
+
+	lineNum := fmt.Sprint(e.Span.Line)
+	pad := strings.Repeat(" ", len(lineNum))
+	fmt.Fprintf(&b, "%s--> %s:%d:%d\n", pad, file, e.Span.Line, e.Span.Column)
+
+	lines := strings.Split(source, "\n")
+	if e.Span.Line > 0 && e.Span.Line <= len(lines) {
+		line := strings.TrimRight(lines[e.Span.Line-1], "\r")
+		fmt.Fprintf(&b, "%s |\n", pad)
+		fmt.Fprintf(&b, "%s | %s\n", lineNum, line)
+		fmt.Fprintf(&b, "%s | %s\n", pad, marker(line, e.Span))
+	}
+
+	if e.Hint != "" {
+		fmt.Fprintf(&b, "%s = hint: %s\n", pad, e.Hint)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Returns a line of carets under the span in the given source line. Spans going
+// over multiple lines are marked to the end of the first line. Columns count
+// characters, not bytes.
+func marker(source string, span Span) string {
+	line := []rune(source)
+	start := span.Column - 1
+	if start < 0 || start > len(line) {
+		return ""
+	}
+
+	end := span.EndColumn
+	if span.EndLine != span.Line || end > len(line) {
+		end = len(line)
+	}
+
+	// Keep tabs so the marker lines up with the source
+	prefix := line[:start]
+	for i, c := range prefix {
+		if c != '\t' {
+			prefix[i] = ' '
+		}
+	}
+
+	width := end - start
+	if width < 1 {
+		width = 1
+	}
+
+	return string(prefix) + strings.Repeat("^", width)
+}
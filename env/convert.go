@@ -0,0 +1,142 @@
+package env
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/jesperkha/Fizz/diag"
+)
+
+var (
+	ErrUnsupportedType = diag.New(diag.TypeError, "E247", "cannot convert Go type %s to a Fizz value")
+)
+
+// Converts a Go value to the matching Fizz value. Integers become int, or
+// *big.Int if they do not fit, and floats become float64. Slices and arrays
+// become arrays, and maps with string keys and structs become objects.
+// Functions of type CallFunction become callables. Fizz values are returned
+// as they are.
+func ToFizz(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, string, bool, int, float64, *Array, *Object, *Map, *Callable:
+		return v, nil
+	case *big.Int:
+		return FromBig(v), nil
+	case CallFunction:
+		return NewFunction("function", -1, v), nil
+	case func(...interface{}) (interface{}, error):
+		return NewFunction("function", -1, v), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return FromBig(big.NewInt(rv.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return FromBig(new(big.Int).SetUint64(rv.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+
+		return ToFizz(rv.Elem().Interface())
+
+	case reflect.Slice, reflect.Array:
+		values := []interface{}{}
+		for i := 0; i < rv.Len(); i++ {
+			v, err := ToFizz(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, v)
+		}
+
+		return NewArray(values), nil
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+
+		fields := map[string]interface{}{}
+		iter := rv.MapRange()
+		for iter.Next() {
+			v, err := ToFizz(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			fields[iter.Key().String()] = v
+		}
+
+		return NewObject("object", fields), nil
+
+	case reflect.Struct:
+		// Exported fields are used with the first letter lowercased to follow
+		// Fizz naming conventions, same as library functions.
+		fields := map[string]interface{}{}
+		typ := rv.Type()
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.PkgPath != "" {
+				continue // Not exported
+			}
+
+			v, err := ToFizz(rv.Field(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			name := strings.ToLower(field.Name[:1]) + field.Name[1:]
+			fields[name] = v
+		}
+
+		return NewObject(typ.Name(), fields), nil
+	}
+
+	return nil, ErrUnsupportedType.With(reflect.TypeOf(value).String())
+}
+
+// Converts a Fizz value to a plain Go value. Arrays become []interface{},
+// objects become map[string]interface{}, and maps become
+// map[interface{}]interface{}. Callables are returned as they are so they can
+// be called from Go.
+func ToGo(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *Array:
+		values := make([]interface{}, len(v.Values))
+		for i, val := range v.Values {
+			values[i] = ToGo(val)
+		}
+
+		return values
+
+	case *Object:
+		fields := make(map[string]interface{}, len(v.Fields))
+		for k, val := range v.Fields {
+			fields[k] = ToGo(val)
+		}
+
+		return fields
+
+	case *Map:
+		values := make(map[interface{}]interface{}, len(v.Keys))
+		for _, k := range v.Keys {
+			val, _ := v.Get(k)
+			values[ToGo(k)] = ToGo(val)
+		}
+
+		return values
+	}
+
+	return value
+}
@@ -11,3 +11,7 @@ func NewFunction(name string, numArgs int, callback CallFunction) *Callable {
 func NewArray(elements []interface{}) *Array {
 	return &Array{Values: elements, Length: len(elements)}
 }
+
+func NewMap() *Map {
+	return &Map{Values: map[interface{}]interface{}{}}
+}
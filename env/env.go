@@ -1,18 +1,22 @@
 package env
 
 import (
-	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/jesperkha/Fizz/diag"
 )
 
 const (
-	// Max number of function names printed upon error
+	// Max number of function names in the chain of a recursion error
 	CallStackSize = 10
 )
 
 var (
-	ThrowEnvironment = true
+	ErrAlreadyDefined = diag.New(diag.NameError, "E241", "variable '%s' is already defined")
+	ErrUndefined      = diag.New(diag.NameError, "E242", "undefined variable '%s'")
+
+	ErrMaximumRecursion = diag.New(diag.RuntimeError, "E248", "maximum recursion depth of %d exceeded: %s")
 )
 
 type valueMap map[string]interface{}
@@ -21,102 +25,211 @@ type valueMap map[string]interface{}
 // order will be from low to high level scopes.
 type Environment []valueMap
 
-var currentEnv = CopyEnvironment(StandardEnvironment)
-var tempEnv = currentEnv
+// Frame is a single function call in the callstack. The file and span are of
+// the call site, not the function declaration.
+type Frame struct {
+	Name string
+	File string
+	Span diag.Span
+	Args []interface{}
+}
+
+// Runtime holds all state for a single running program: the variable scopes,
+// the callstack and recursion tracking. Every interpreter has its own runtime,
+// so several programs can run side by side without sharing any state.
+type Runtime struct {
+	// Creates a new environment after each run when true. Set to false by the
+	// terminal so variables are remembered between inputs.
+	ThrowEnvironment bool
+
+	// Name of the file currently being executed. Used as origin for function
+	// declarations so errors give the correct filename when printed, and as the
+	// file of call sites in the callstack.
+	Origin string
 
-// Callstack is slice of names/origins of functions. It is only appended to from
-// failing functions, and the ripple back effect from the returned errors will
-// fill it with the names of the failed functions.
-var callStack = []string{}
+	// Max number of function calls on the callstack at once
+	MaxRecursionDepth int
 
-// Appends failed function to callstack.
-func FailCall(name string, origin string, line int) {
-	callStack = append(callStack, fmt.Sprintf("\tat %s() in %s, line %d", name, origin, line))
+	currentEnv Environment
+	tempEnvs   []Environment
+
+	// Frames of the functions currently running, outermost first. The trace is
+	// a copy of the callstack taken when the last error was raised.
+	callStack []Frame
+	trace     []Frame
 }
 
-// Get print ready format of callstack for errors.
-func GetCallstack() string {
-	if len(callStack) > CallStackSize {
-		str := strings.Join(callStack[:CallStackSize], "\n")
-		return str + "\n\t..."
+// Creates a new runtime with a copy of the standard environment.
+func NewRuntime() *Runtime {
+	return &Runtime{
+		ThrowEnvironment:  true,
+		MaxRecursionDepth: 1000,
+		currentEnv:        CopyEnvironment(StandardEnvironment),
 	}
+}
 
-	return strings.Join(callStack, "\n")
+// Pushes a frame for a function call to the callstack. Returns error if the
+// callstack is already at the max recursion depth. The frame is not pushed in
+// that case, and the callstack is recorded as the trace for the error.
+func (r *Runtime) PushCall(frame Frame) error {
+	if len(r.callStack) >= r.MaxRecursionDepth {
+		r.recordTrace()
+		return ErrMaximumRecursion.With(r.MaxRecursionDepth, r.recursionChain(frame.Name))
+	}
+
+	r.callStack = append(r.callStack, frame)
+	return nil
+}
+
+// Returns the chain of calls that led to the named function being called
+// again, like "a -> b -> a". Falls back to the last calls made if the
+// function has not been called before.
+func (r *Runtime) recursionChain(name string) string {
+	start := len(r.callStack) - 1
+	for start >= 0 && r.callStack[start].Name != name {
+		start--
+	}
+
+	if start < 0 && len(r.callStack) > CallStackSize {
+		start = len(r.callStack) - CallStackSize
+	} else if start < 0 {
+		start = 0
+	}
+
+	names := []string{}
+	for _, f := range r.callStack[start:] {
+		names = append(names, f.Name)
+	}
+
+	return strings.Join(append(names, name), " -> ")
+}
+
+// Pops the frame of the returning function. If the function failed, the
+// callstack is recorded as the trace for the error. Only the innermost call
+// records it, as the error ripples back through the other frames.
+func (r *Runtime) PopCall(err error) {
+	if err != nil {
+		r.recordTrace()
+	}
+
+	r.callStack = r.callStack[:len(r.callStack)-1]
+}
+
+// Copies the callstack to the trace, innermost frame first, unless a trace has
+// already been recorded for the current error.
+func (r *Runtime) recordTrace() {
+	if r.trace != nil {
+		return
+	}
+
+	r.trace = []Frame{}
+	for i := len(r.callStack) - 1; i >= 0; i-- {
+		r.trace = append(r.trace, r.callStack[i])
+	}
+}
+
+// Returns the callstack at the point the last error was raised, innermost
+// frame first. Empty if the error was raised outside of a function.
+func (r *Runtime) Callstack() []Frame {
+	return r.trace
+}
+
+// Clears the trace of the last error. Called before a new run and when an
+// error is caught.
+func (r *Runtime) ResetCallstack() {
+	r.trace = nil
 }
 
 // Creates new environment, replacing the old one. Returns old environment. For
 // testing, its not necessary to get rid of the old env, hence the option to not
 // remove it.
-func NewEnvironment() Environment {
-	if !ThrowEnvironment {
-		return currentEnv
+func (r *Runtime) NewEnvironment() Environment {
+	if !r.ThrowEnvironment {
+		return r.currentEnv
 	}
 
-	oldEnv := currentEnv
-	currentEnv = CopyEnvironment(StandardEnvironment)
+	oldEnv := r.currentEnv
+	r.currentEnv = CopyEnvironment(StandardEnvironment)
 	return oldEnv
 }
 
 // Declares value to name in current scope. This allows for overriding global
 // variable names for local scopes. Returns error if name is already declared.
-func Declare(name string, value interface{}) error {
-	curScope := currentEnv[0]
+func (r *Runtime) Declare(name string, value interface{}) error {
+	curScope := r.currentEnv[0]
 	if _, ok := curScope[name]; !ok {
 		curScope[name] = value
 		return nil
 	}
 
-	return errors.New("variable '" + name + "' is already defined, line %d")
+	return ErrAlreadyDefined.With(name)
 }
 
 // Assigns value to name. If name is not defined in current scope the parent
 // scopes are checked. Therefore, reassignment of global variables in local
 // scopes is possible. Returns error if name is not defined anywhere.
-func Assign(name string, value interface{}) error {
-	for _, scope := range currentEnv {
+func (r *Runtime) Assign(name string, value interface{}) error {
+	for _, scope := range r.currentEnv {
 		if _, ok := scope[name]; ok {
 			scope[name] = value
 			return nil
 		}
 	}
 
-	return errors.New("undefined variable '" + name + "', line %d")
+	return r.undefined(name)
 }
 
 // Gets the value assigned to name. If the name is not defined in the current
 // scope the parent scopes are checked. Gets the first instance of name. Returns
 // error if name is not defined anywhere.
-func Get(name string) (value interface{}, err error) {
-	for _, scope := range currentEnv {
+func (r *Runtime) Get(name string) (value interface{}, err error) {
+	for _, scope := range r.currentEnv {
 		if value, ok := scope[name]; ok {
 			return value, nil
 		}
 	}
 
-	return value, errors.New("undefined variable '" + name + "', line %d")
+	return value, r.undefined(name)
+}
+
+// Returns undefined variable error with a hint if a similar name is defined.
+func (r *Runtime) undefined(name string) error {
+	err := ErrUndefined.With(name)
+	names := []string{}
+	for _, scope := range r.currentEnv {
+		for n := range scope {
+			names = append(names, n)
+		}
+	}
+
+	if closest, ok := diag.Closest(name, names); ok {
+		err.Hint = fmt.Sprintf("did you mean '%s'?", closest)
+	}
+
+	return err
 }
 
 // Puts new scope at beginning of slice, effectivly setting the previous scope
 // as the parent of the new. (slice is reverse stack)
-func PushScope() {
-	currentEnv = append([]valueMap{{}}, currentEnv...)
+func (r *Runtime) PushScope() {
+	r.currentEnv = append([]valueMap{{}}, r.currentEnv...)
 }
 
 // Removes first element in slice, meaning the parent scope is set to the current.
 // Unsafe: if the length of the slice is 1, pop will panic. However, the use of Push()
 // and Pop() is hardcoded and will never cause a pop of a scope list smaller than 2.
-func PopScope() {
-	if len(currentEnv) < 2 {
+func (r *Runtime) PopScope() {
+	if len(r.currentEnv) < 2 {
 		panic("env: popped scope list of length < 2")
 	}
 
-	currentEnv = currentEnv[1:]
+	r.currentEnv = r.currentEnv[1:]
 }
 
 // Adds environment from imported file to current env. Values are passed as an object.
 // The fields are the values in the global scope of the environment (index 0).
-func AddImportedFile(name string, env Environment) error {
-	return Declare(name, &Object{
+func (r *Runtime) AddImportedFile(name string, env Environment) error {
+	return r.Declare(name, &Object{
 		Name:      name,
 		NumFields: len(env[0]),
 		Fields:    env[0],
@@ -126,9 +239,9 @@ func AddImportedFile(name string, env Environment) error {
 // Gets a snapshot of the current env. Used to disallow changes made to the current
 // env to be accessed in closures. More static approach. Unsafe: gets temp env if
 // one is in use.
-func GetCurrentEnvSnapshot() Environment {
+func (r *Runtime) GetCurrentEnvSnapshot() Environment {
 	temp := Environment{}
-	for idx, m := range currentEnv {
+	for idx, m := range r.currentEnv {
 		temp = append(temp, valueMap{})
 		for k, v := range m {
 			temp[idx][k] = v
@@ -140,21 +253,24 @@ func GetCurrentEnvSnapshot() Environment {
 
 // Returns the current env. Used instead of GetCurrentEnvSnapshot to allow for the
 // enviroment changes to be available inside a closure.
-func GetCurrentEnv() Environment {
-	return currentEnv
+func (r *Runtime) GetCurrentEnv() Environment {
+	return r.currentEnv
 }
 
 // Sets a new temporary envirnoment. Used for closures since envs are not passed as
 // arguments to any functions in this file. Is discarded upon calling PopTempEnv().
-func PushTempEnv(env Environment) {
-	tempEnv = currentEnv
-	currentEnv = env
+// The replaced environments are kept in a stack so nested calls restore the
+// environment of their caller.
+func (r *Runtime) PushTempEnv(env Environment) {
+	r.tempEnvs = append(r.tempEnvs, r.currentEnv)
+	r.currentEnv = env
 }
 
 // Unsafe: does not check if there is a current temp env or not, however, its use is
 // hardcoded and will not be called when there is no temporary environment.
-func PopTempEnv() {
-	currentEnv = tempEnv
+func (r *Runtime) PopTempEnv() {
+	r.currentEnv = r.tempEnvs[len(r.tempEnvs)-1]
+	r.tempEnvs = r.tempEnvs[:len(r.tempEnvs)-1]
 }
 
 // Copies environment to not use a reference of the old one.
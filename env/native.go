@@ -1,17 +1,23 @@
 package env
 
+import "unicode/utf8"
+
 var StandardEnvironment = Environment{{
 	"len": NewFunction("len", 1, func(i ...interface{}) (interface{}, error) {
 		if arr, ok := i[0].(*Array); ok {
-			return float64(arr.Length), nil
+			return arr.Length, nil
 		}
 
-		// Strings too
+		// Strings and maps too
 		if str, ok := i[0].(string); ok {
-			return float64(len(str)), nil
+			return utf8.RuneCountInString(str), nil
+		}
+
+		if m, ok := i[0].(*Map); ok {
+			return len(m.Keys), nil
 		}
 
-		return -1, ErrNotArray
+		return -1, ErrNotArray.With(TypeOf(i[0]))
 	}),
 
 	"push": NewFunction("push", 2, func(i ...interface{}) (interface{}, error) {
@@ -20,7 +26,7 @@ var StandardEnvironment = Environment{{
 			return nil, nil
 		}
 
-		return -1, ErrNotArray
+		return -1, ErrNotArray.With(TypeOf(i[0]))
 	}),
 
 	"pop": NewFunction("pop", 1, func(i ...interface{}) (interface{}, error) {
@@ -28,6 +34,6 @@ var StandardEnvironment = Environment{{
 			return arr.Pop()
 		}
 
-		return -1, ErrNotArray
+		return -1, ErrNotArray.With(TypeOf(i[0]))
 	}),
 }}
@@ -0,0 +1,101 @@
+package env
+
+import "math/big"
+
+// Numbers are either integers or floats. Integers are exact and are stored
+// as int, or as *big.Int when they do not fit in an int. Big integers that
+// fit in an int again are always stored as int, so two equal integers have
+// the same Go type. Floats are float64.
+
+// Reports whether value is an integer or a float
+func IsNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, *big.Int, float64:
+		return true
+	}
+
+	return false
+}
+
+// Reports whether value is an integer, big or not
+func IsInteger(value interface{}) bool {
+	switch value.(type) {
+	case int, *big.Int:
+		return true
+	}
+
+	return false
+}
+
+// Returns the number as a float64. Big integers are rounded to the nearest
+// float. Ok is false if value is not a number.
+func ToFloat(value interface{}) (f float64, ok bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case *big.Int:
+		f, _ = new(big.Float).SetInt(v).Float64()
+		return f, true
+	}
+
+	return f, false
+}
+
+// Returns the integer as a new big.Int. Ok is false if value is not an
+// integer.
+func ToBig(value interface{}) (b *big.Int, ok bool) {
+	switch v := value.(type) {
+	case int:
+		return big.NewInt(int64(v)), true
+	case *big.Int:
+		return new(big.Int).Set(v), true
+	}
+
+	return b, false
+}
+
+// Returns b as an int if it fits, and as a *big.Int otherwise
+func FromBig(b *big.Int) interface{} {
+	if n := b.Int64(); b.IsInt64() && int64(int(n)) == n {
+		return int(n)
+	}
+
+	return b
+}
+
+// Compares two numbers and returns -1, 0, or 1 if a is less than, equal to,
+// or greater than b. Integers are compared exactly. When one of them is a
+// float, both are compared as floats, and NaN is equal to everything.
+func CompareNumbers(a interface{}, b interface{}) int {
+	if x, ok := a.(int); ok {
+		if y, ok := b.(int); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+
+			return 0
+		}
+	}
+
+	if IsInteger(a) && IsInteger(b) {
+		x, _ := ToBig(a)
+		y, _ := ToBig(b)
+		return x.Cmp(y)
+	}
+
+	x, _ := ToFloat(a)
+	y, _ := ToFloat(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+
+	return 0
+}
@@ -1,19 +1,57 @@
 package env
 
 import (
-	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/jesperkha/Fizz/diag"
 )
 
 var (
-	ErrNotAField       = errors.New("'%s' has no attribute '%s', line %d")
-	ErrIndexOutOfRange = errors.New("index out of range, line %d")
-	ErrNotArray        = errors.New("type %s is not an array, line %d")
-	ErrEmptyArray      = errors.New("cannot pop from empty array, line %d")
+	ErrNotAField       = diag.New(diag.NameError, "E243", "'%s' has no attribute '%s'")
+	ErrIndexOutOfRange = diag.New(diag.RuntimeError, "E244", "index out of range")
+	ErrNotArray        = diag.New(diag.TypeError, "E245", "type %s is not an array")
+	ErrEmptyArray      = diag.New(diag.RuntimeError, "E246", "cannot pop from empty array")
+	ErrInvalidKey      = diag.New(diag.TypeError, "E249", "type %s cannot be used as map key")
+	ErrKeyNotFound     = diag.New(diag.RuntimeError, "E250", "key %s not found in map")
+	ErrNotDefinition   = diag.New(diag.TypeError, "E252", "type %s is not an object definition")
+	ErrInherited       = diag.New(diag.NameError, "E253", "'%s' is already defined by '%s'")
 )
 
-// Performs recursive equality check for objects and arrays.
-// Other cases returns standard equality check.
+// Returns Fizz name for the type of value
+func TypeOf(value interface{}) string {
+	if i, ok := value.(FizzObject); ok {
+		return i.Type()
+	}
+
+	switch value.(type) {
+	case float64, float32, int, *big.Int:
+		return "number"
+	case nil:
+		return "nil"
+	}
+
+	return reflect.TypeOf(value).Name()
+}
+
+// Performs recursive equality check for objects and arrays. Numbers are
+// equal if they have the same value, so 1 and 1.0 are equal. Other cases
+// returns standard equality check.
 func Equal(left, right interface{}) bool {
+	if IsNumber(left) && IsNumber(right) {
+		_, lf := left.(float64)
+		_, rf := right.(float64)
+		if lf || rf {
+			a, _ := ToFloat(left)
+			b, _ := ToFloat(right)
+			return a == b
+		}
+
+		return CompareNumbers(left, right) == 0
+	}
+
 	l, r := "", ""
 	if lo, ok := left.(FizzObject); ok {
 		l = lo.Type()
@@ -35,6 +73,12 @@ func Equal(left, right interface{}) bool {
 		return a.IsEqual(b)
 	}
 
+	if l == "map" && r == "map" {
+		a, _ := left.(*Map)
+		b, _ := right.(*Map)
+		return a.IsEqual(b)
+	}
+
 	return left == right
 }
 
@@ -49,23 +93,79 @@ type CallFunction func(...interface{}) (interface{}, error)
 // Callable object is a function. The origin is the name of the file it
 // was defined in. Error returned from Call() is printed as a Fizz error
 // and is not a return value.
+// Definition is only set for constructors made by define statements.
 type Callable struct {
-	Name    string
-	Origin  string
-	Call    CallFunction
-	NumArgs int
+	Name       string
+	Origin     string
+	Call       CallFunction
+	NumArgs    int
+	Definition *Definition
 }
 
 func (c *Callable) Type() string {
 	return "function"
 }
 
+// Object type made by a define statement. Fields includes the fields of
+// the parent definition, which come first. Methods take the instance as
+// their first argument and the parent methods, super, as the second.
+type Definition struct {
+	Name    string
+	Fields  []string
+	Methods map[string]*Callable
+	Parent  *Definition
+}
+
+// Reports whether d is other or inherits from it
+func (d *Definition) Extends(other *Definition) bool {
+	for ; d != nil; d = d.Parent {
+		if d == other {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the method with the given name and the definition it was
+// declared in. Methods in d override the ones in its parents.
+func (d *Definition) Method(name string) (method *Callable, owner *Definition, ok bool) {
+	for ; d != nil; d = d.Parent {
+		if method, ok = d.Methods[name]; ok {
+			return method, d, ok
+		}
+	}
+
+	return method, owner, false
+}
+
+// Returns the name of the definition that has a field or method with the
+// given name, and false if there is none.
+func (d *Definition) Defines(name string) (string, bool) {
+	for _, field := range d.Fields {
+		if field == name {
+			return d.Name, true
+		}
+	}
+
+	if _, owner, ok := d.Method(name); ok {
+		return owner.Name, true
+	}
+
+	return "", false
+}
+
 // Object with n fields. Name is the name of the constructor, not the
-// instance. File imports are also objects.
+// instance. File imports are also objects. Definition is nil for objects
+// not made by a define statement.
 type Object struct {
-	Fields    map[string]interface{}
-	NumFields int
-	Name      string
+	Fields     map[string]interface{}
+	NumFields  int
+	Name       string
+	Definition *Definition
+
+	// Super objects made for the object, by the definition that uses them
+	supers map[*Definition]interface{}
 }
 
 func (o *Object) Type() string {
@@ -92,13 +192,77 @@ func (o *Object) IsEqual(a *Object) bool {
 	return true
 }
 
-// Gets value from object. Used for getter syntax "name.value"
+// Gets value from object. Used for getter syntax "name.value". Methods are
+// returned bound to the object, so the instance does not need to be passed
+// when calling them.
 func (o *Object) Get(name string) (value interface{}, err error) {
 	if val, ok := o.Fields[name]; ok {
 		return val, err
 	}
 
-	return value, ErrNotAField
+	if method, owner, ok := o.Definition.Method(name); ok {
+		return o.bind(name, method, owner), err
+	}
+
+	return value, ErrNotAField.With(o.Name, name)
+}
+
+// Returns names of all fields and methods of the object
+func (o *Object) Names() []string {
+	names := []string{}
+	for name := range o.Fields {
+		names = append(names, name)
+	}
+
+	for d := o.Definition; d != nil; d = d.Parent {
+		for name := range d.Methods {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Returns method with the object and super passed as the first arguments.
+// Owner is the definition the method was declared in.
+func (o *Object) bind(name string, method *Callable, owner *Definition) *Callable {
+	return &Callable{
+		Name:    owner.Name + "." + name,
+		Origin:  method.Origin,
+		NumArgs: method.NumArgs - 2,
+		Call: func(args ...interface{}) (interface{}, error) {
+			return method.Call(append([]interface{}{o, o.super(owner)}, args...)...)
+		},
+	}
+}
+
+// Returns an object with the methods of the parent of owner bound to o,
+// or nil if owner has no parent. It is made the first time it is needed and
+// reused by later calls.
+func (o *Object) super(owner *Definition) interface{} {
+	if owner.Parent == nil {
+		return nil
+	}
+
+	if super, ok := o.supers[owner]; ok {
+		return super
+	}
+
+	methods := map[string]interface{}{}
+	for d := owner.Parent; d != nil; d = d.Parent {
+		for name, method := range d.Methods {
+			if _, ok := methods[name]; !ok {
+				methods[name] = o.bind(name, method, d)
+			}
+		}
+	}
+
+	if o.supers == nil {
+		o.supers = map[*Definition]interface{}{}
+	}
+
+	o.supers[owner] = NewObject(owner.Parent.Name, methods)
+	return o.supers[owner]
 }
 
 // Reassigns value to object. Does not declare since object have a
@@ -109,7 +273,7 @@ func (o *Object) Set(name string, value interface{}) (err error) {
 		return err
 	}
 
-	return ErrNotAField
+	return ErrNotAField.With(o.Name, name)
 }
 
 // Stores length value for ease of use. Append elements with += operator.
@@ -137,9 +301,13 @@ func (a *Array) IsEqual(o *Array) bool {
 	return true
 }
 
-// Gets value of array at index. Returns error if value is > len(arr) or
-// index is less than 0.
+// Gets value of array at index. Negative indices count from the end, so -1
+// is the last value. Returns error if the index is out of range.
 func (a Array) Get(index int) (value interface{}, err error) {
+	if index < 0 {
+		index += len(a.Values)
+	}
+
 	if index >= len(a.Values) || index < 0 {
 		return value, ErrIndexOutOfRange
 	}
@@ -147,8 +315,12 @@ func (a Array) Get(index int) (value interface{}, err error) {
 	return a.Values[index], err
 }
 
-// Sets value at given index.
+// Sets value at given index. Negative indices count from the end.
 func (a Array) Set(index int, value interface{}) error {
+	if index < 0 {
+		index += a.Length
+	}
+
 	if index >= a.Length || index < 0 {
 		return ErrIndexOutOfRange
 	}
@@ -175,3 +347,142 @@ func (a *Array) Pop() (value interface{}, err error) {
 	a.Length--
 	return popped, nil
 }
+
+// Map of keys to values. Keys can be strings, numbers, or bools. The keys are
+// kept in the order they were inserted, which is the order they are printed
+// and looped over in. Floats with an integer value are stored as integers, so
+// 1 and 1.0 are the same key. Values is indexed by mapKey, not by the keys.
+type Map struct {
+	Values map[interface{}]interface{}
+	Keys   []interface{}
+}
+
+func (m *Map) Type() string {
+	return "map"
+}
+
+// Compare two maps. Maps are equal if they have the same keys and values,
+// regardless of order.
+func (m *Map) IsEqual(o *Map) bool {
+	if len(m.Keys) != len(o.Keys) {
+		return false
+	}
+
+	for k, v := range m.Values {
+		ov, ok := o.Values[k]
+		if !ok || !Equal(v, ov) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns true if key is in map
+func (m *Map) Has(key interface{}) bool {
+	if !validKey(key) {
+		return false
+	}
+
+	_, ok := m.Values[mapKey(key)]
+	return ok
+}
+
+// Gets value of key. Returns error if key is not in map.
+func (m *Map) Get(key interface{}) (value interface{}, err error) {
+	if !validKey(key) {
+		return value, ErrInvalidKey.With(TypeOf(key))
+	}
+
+	if value, ok := m.Values[mapKey(key)]; ok {
+		return value, err
+	}
+
+	return value, ErrKeyNotFound.With(formatKey(key))
+}
+
+// Sets value of key. The key is added if it is not already in the map.
+func (m *Map) Set(key interface{}, value interface{}) error {
+	if !validKey(key) {
+		return ErrInvalidKey.With(TypeOf(key))
+	}
+
+	key = normalizeKey(key)
+	if _, ok := m.Values[mapKey(key)]; !ok {
+		m.Keys = append(m.Keys, key)
+	}
+
+	m.Values[mapKey(key)] = value
+	return nil
+}
+
+// Removes key from map. Does nothing if the key is not in the map.
+func (m *Map) Delete(key interface{}) {
+	if !m.Has(key) {
+		return
+	}
+
+	key = mapKey(key)
+	delete(m.Values, key)
+	for i, k := range m.Keys {
+		if mapKey(k) == key {
+			m.Keys = append(m.Keys[:i], m.Keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Returns the method with the given name bound to the map. Maps have a
+// delete method, so keys are removed with m.delete(key).
+func (m *Map) Method(name string) (method *Callable, ok bool) {
+	if name == "delete" {
+		return NewFunction("delete", 1, func(args ...interface{}) (interface{}, error) {
+			m.Delete(args[0])
+			return nil, nil
+		}), true
+	}
+
+	return method, false
+}
+
+func validKey(key interface{}) bool {
+	switch key.(type) {
+	case string, int, *big.Int, float64, bool:
+		return true
+	}
+
+	return false
+}
+
+// Big integers are keyed by their decimal string. It has its own type so it
+// never matches a string key.
+type bigKey string
+
+// Returns floats with an integer value as integers so they match integer keys
+func normalizeKey(key interface{}) interface{} {
+	if f, ok := key.(float64); ok && f == math.Trunc(f) && !math.IsInf(f, 0) {
+		n, _ := new(big.Float).SetFloat64(f).Int(nil)
+		return FromBig(n)
+	}
+
+	return key
+}
+
+// Returns the key used to index the values of a map. Equal numbers give the
+// same key.
+func mapKey(key interface{}) interface{} {
+	key = normalizeKey(key)
+	if b, ok := key.(*big.Int); ok {
+		return bigKey(b.String())
+	}
+
+	return key
+}
+
+func formatKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return fmt.Sprint(key)
+}
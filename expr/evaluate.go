@@ -2,9 +2,9 @@ package expr
 
 import (
 	"fmt"
-	"math"
 	"strings"
 
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/env"
 	"github.com/jesperkha/Fizz/lexer"
 	"github.com/jesperkha/Fizz/util"
@@ -12,50 +12,47 @@ import (
 
 // Evaluates expression tree. Hands off to helper methods which can also recursively call to
 // resolve nested expressions. Returned value is result of expression and is Go literal.
-func EvaluateExpression(expr *Expression) (value interface{}, err error) {
+func EvaluateExpression(rt *env.Runtime, expr *Expression) (value interface{}, err error) {
 	switch expr.Type {
 	case Literal:
 		return evalLiteral(expr)
 	case Unary:
-		return evalUnary(expr)
+		return evalUnary(rt, expr)
 	case Binary:
-		return evalBinary(expr)
+		return evalBinary(rt, expr)
 	case Group:
-		return EvaluateExpression(expr.Inner)
+		return EvaluateExpression(rt, expr.Inner)
 	case Variable:
-		return env.Get(expr.Name)
+		value, err = rt.Get(expr.Name)
+		return value, diag.Locate(err, expr.Span)
 	case Call:
-		return evalCall(expr)
+		return evalCall(rt, expr)
 	case Getter:
-		return evalGetter(expr)
+		return evalGetter(rt, expr)
 	case Array:
-		return evalArray(expr)
+		return evalArray(rt, expr)
 	case Index:
-		return evalIndex(expr)
+		return evalIndex(rt, expr)
+	case Lambda:
+		return EvalLambda(rt, expr.Lambda)
+	case Map:
+		return evalMap(rt, expr)
+	case Conditional:
+		return evalConditional(rt, expr)
+	case Slice:
+		return evalSlice(rt, expr)
+	case Interpolation:
+		return evalInterpolation(rt, expr)
 	}
 
 	// Wont be reached
 	return expr, ErrInvalidExpression
 }
 
-// Performs equality check and parsing for arrays and object
+// Performs equality check and parsing for arrays, objects, and maps
 // because they are pointers and cannot be compared as addresses.
 func equal(left, right interface{}) bool {
-	l, r := util.GetType(left), util.GetType(right)
-
-	if l == "array" && r == "array" {
-		a, _ := left.(*env.Array)
-		b, _ := right.(*env.Array)
-		return a.IsEqual(b)
-	}
-
-	if l == "object" && r == "object" {
-		a, _ := left.(*env.Object)
-		b, _ := right.(*env.Object)
-		return a.IsEqual(b)
-	}
-
-	return left == right
+	return env.Equal(left, right)
 }
 
 // Token types >= string are valid literal types
@@ -67,8 +64,8 @@ func evalLiteral(literal *Expression) (value interface{}, err error) {
 	return value, ErrInvalidExpression
 }
 
-func evalUnary(unary *Expression) (value interface{}, err error) {
-	right, err := EvaluateExpression(unary.Right)
+func evalUnary(rt *env.Runtime, unary *Expression) (value interface{}, err error) {
+	right, err := EvaluateExpression(rt, unary.Right)
 	if err != nil {
 		return value, err
 	}
@@ -77,63 +74,66 @@ func evalUnary(unary *Expression) (value interface{}, err error) {
 	switch unary.Operand.Type {
 	case lexer.MINUS:
 		if isNumber(right) {
-			return -right.(float64), err
+			return negate(right), err
 		}
-		op, typ, line := unary.Operand.Lexeme, util.GetType(right), unary.Line
-		return nil, fmt.Errorf(ErrInvalidOperatorType.Error(), op, typ, line)
+		op, typ := unary.Operand.Lexeme, util.GetType(right)
+		return nil, ErrInvalidOperatorType.At(unary.Span, op, typ)
 	case lexer.NOT:
 		return !isTruthy(right), err
+	case lexer.BIT_NOT:
+		if !env.IsInteger(right) {
+			return nil, ErrExpectedIntegers.At(unary.Span, unary.Operand.Lexeme, typeName(right))
+		}
+		return bitNot(right), err
 	case lexer.TYPE:
 		return util.GetType(right), err
 	}
 
 	// If none of the mentioned operators are present its an invalid one
-	op, line := unary.Operand.Lexeme, unary.Line
-	return value, fmt.Errorf(ErrInvalidUnaryOperator.Error(), op, line)
+	op := unary.Operand.Lexeme
+	return value, ErrInvalidUnaryOperator.At(unary.Span, op)
 }
 
-func evalBinary(binary *Expression) (value interface{}, err error) {
+func evalBinary(rt *env.Runtime, binary *Expression) (value interface{}, err error) {
 	opType := binary.Operand.Type
 
 	// Recursivly evaluates left and right expressions
-	left, err := EvaluateExpression(binary.Left)
+	left, err := EvaluateExpression(rt, binary.Left)
 	if err != nil {
 		return nil, err
 	}
 
-	right, err := EvaluateExpression(binary.Right)
+	// Logical operators only evaluate the right side if the left side does
+	// not decide the result, and return the deciding value
+	if opType == lexer.AND && !isTruthy(left) || opType == lexer.OR && isTruthy(left) {
+		return left, err
+	}
+
+	if opType == lexer.NIL_COALESCE && left != nil {
+		return left, err
+	}
+
+	right, err := EvaluateExpression(rt, binary.Right)
 	if err != nil {
 		return nil, err
 	}
 
-	// Operations if both are number types
-	if isNumber(right) && isNumber(left) {
-		vl, vr := left.(float64), right.(float64)
-		switch opType {
-		case lexer.PLUS:
-			return vl + vr, err
-		case lexer.MINUS:
-			return vl - vr, err
-		case lexer.STAR:
-			return vl * vr, err
-		case lexer.HAT:
-			return math.Pow(vl, vr), err
-		case lexer.GREATER:
-			return vl > vr, err
-		case lexer.LESS:
-			return vl < vr, err
-		case lexer.LESS_EQUAL:
-			return vl <= vr, err
-		case lexer.GREATER_EQUAL:
-			return vl >= vr, err
-		case lexer.MODULO:
-			return float64(int(vl) % int(vr)), err
-		case lexer.SLASH:
-			if vr == 0 {
-				return nil, util.FormatError(ErrDivideByZero, binary.Line)
+	// Bitwise operators and floor division only work on integers
+	if isIntegerOperator(opType) {
+		for _, v := range []interface{}{left, right} {
+			if !env.IsInteger(v) {
+				return nil, ErrExpectedIntegers.At(binary.Span, binary.Operand.Lexeme, typeName(v))
 			}
-			return vl / vr, err
 		}
+
+		value, err = IntegerOperation(opType, left, right)
+		return value, diag.Locate(err, binary.Span)
+	}
+
+	// Operations if both are number types
+	if isNumber(right) && isNumber(left) && isNumberOperator(opType) {
+		value, err = Arithmetic(opType, left, right)
+		return value, diag.Locate(err, binary.Span)
 	}
 
 	// Types do not need to match for comparisons
@@ -142,10 +142,8 @@ func evalBinary(binary *Expression) (value interface{}, err error) {
 		return equal(left, right), err
 	case lexer.NOT_EQUAL:
 		return !equal(left, right), err
-	case lexer.AND:
-		return isTruthy(left) && isTruthy(right), err
-	case lexer.OR:
-		return isTruthy(left) || isTruthy(right), err
+	case lexer.AND, lexer.OR, lexer.NIL_COALESCE:
+		return right, err
 	}
 
 	// Support string addition
@@ -153,6 +151,23 @@ func evalBinary(binary *Expression) (value interface{}, err error) {
 		return strings.Join([]string{left.(string), right.(string)}, ""), err
 	}
 
+	// Binary 'is' operator checks if the object was made by the definition
+	// or one that inherits from it
+	if opType == lexer.IS {
+		constructor, ok := right.(*env.Callable)
+		if !ok || constructor.Definition == nil {
+			return nil, env.ErrNotDefinition.At(binary.Right.Span, util.GetType(right))
+		}
+
+		obj, ok := left.(*env.Object)
+		return ok && obj.Definition.Extends(constructor.Definition), err
+	}
+
+	// Binary 'in' operator for map keys
+	if m, ok := right.(*env.Map); ok && opType == lexer.IN {
+		return m.Has(left), err
+	}
+
 	// Binary 'in' operator for arrays
 	if util.GetType(right) == "array" && opType == lexer.IN {
 		arr, _ := right.(*env.Array)
@@ -167,12 +182,12 @@ func evalBinary(binary *Expression) (value interface{}, err error) {
 
 	// If non of the previous checks worked the expression is invalid
 	typeLeft, typeRight := util.GetType(left), util.GetType(right)
-	op, line := binary.Operand.Lexeme, binary.Line
-	return nil, fmt.Errorf(ErrInvalidOperatorTypes.Error(), op, typeLeft, typeRight, line)
+	op := binary.Operand.Lexeme
+	return nil, ErrInvalidOperatorTypes.At(binary.Span, op, typeLeft, typeRight)
 }
 
-func evalCall(call *Expression) (value interface{}, err error) {
-	callee, err := EvaluateExpression(call.Left)
+func evalCall(rt *env.Runtime, call *Expression) (value interface{}, err error) {
+	callee, err := EvaluateExpression(rt, call.Left)
 	if err != nil {
 		return value, err
 	}
@@ -184,7 +199,7 @@ func evalCall(call *Expression) (value interface{}, err error) {
 
 		// Single argument
 		if argToken.Type != Args && argToken.Type != EmptyExpression {
-			arg, err := EvaluateExpression(call.Inner)
+			arg, err := EvaluateExpression(rt, call.Inner)
 			if err != nil {
 				return value, err
 			}
@@ -195,7 +210,7 @@ func evalCall(call *Expression) (value interface{}, err error) {
 		// Argument list
 		if argToken.Type == Args {
 			for _, arg := range argToken.Exprs {
-				val, err := EvaluateExpression(&arg)
+				val, err := EvaluateExpression(rt, &arg)
 				if err != nil {
 					return value, err
 				}
@@ -206,45 +221,68 @@ func evalCall(call *Expression) (value interface{}, err error) {
 
 		// -1 is set from /lib and should be ignored as it is handled there
 		if len(args) != f.NumArgs && f.NumArgs != -1 {
-			return value, fmt.Errorf(ErrIncorrectArgs.Error(), f.Name, f.NumArgs, len(args), call.Line)
+			return value, ErrIncorrectArgs.At(call.Span, f.Name, f.NumArgs, len(args))
+		}
+
+		// Errors from lib are given the position of the call
+		frame := env.Frame{Name: f.Name, File: rt.Origin, Span: call.Span, Args: args}
+		if err = rt.PushCall(frame); err != nil {
+			return value, diag.Locate(err, call.Span)
 		}
 
-		// Errors from lib need line format
 		value, err = f.Call(args...)
-		return value, util.FormatError(err, call.Line)
+		err = diag.Locate(err, call.Span)
+		rt.PopCall(err)
+		return value, err
 	}
 
-	return value, fmt.Errorf(ErrNotFunction.Error(), util.GetType(callee), call.Line)
+	return value, ErrNotFunction.At(call.Span, util.GetType(callee))
 }
 
-func evalGetter(getter *Expression) (value interface{}, err error) {
-	line := getter.Line
+func evalGetter(rt *env.Runtime, getter *Expression) (value interface{}, err error) {
 	name := getter.Right.Name
 	// No name before dot raises error here. No name after dot raises error in lexer.
 	if getter.Left.Type == EmptyExpression {
-		return value, fmt.Errorf(ErrInvalidExpression.Error(), line)
+		return value, ErrInvalidExpression.At(getter.Span)
 	}
 
 	// Recursively get parent expression, must be object
-	parent, err := EvaluateExpression(getter.Left)
+	parent, err := EvaluateExpression(rt, getter.Left)
 	if err != nil {
 		return value, err
 	}
 
-	// Only objects allow getter expressions
+	if parent == nil && getter.Operand.Type == lexer.SAFE_DOT {
+		return nil, err
+	}
+
+	// Only objects and maps allow getter expressions
 	if obj, ok := parent.(*env.Object); ok {
 		value, err = obj.Get(name)
 		if err != nil {
-			return value, fmt.Errorf(err.Error(), obj.Name, name, line)
+			e := env.ErrNotAField.At(getter.Span, obj.Name, name)
+			if closest, ok := diag.Closest(name, obj.Names()); ok {
+				e.Hint = fmt.Sprintf("did you mean '%s'?", closest)
+			}
+
+			return value, e
 		}
 
 		return value, err
 	}
 
-	return value, fmt.Errorf(ErrNotObject.Error(), util.GetType(parent), line)
+	if m, ok := parent.(*env.Map); ok {
+		if method, ok := m.Method(name); ok {
+			return method, err
+		}
+
+		return value, env.ErrNotAField.At(getter.Span, "map", name)
+	}
+
+	return value, ErrNotObject.At(getter.Span, util.GetType(parent))
 }
 
-func evalArray(array *Expression) (value interface{}, err error) {
+func evalArray(rt *env.Runtime, array *Expression) (value interface{}, err error) {
 	inner := array.Inner
 	if inner.Type == EmptyExpression {
 		return &env.Array{}, err
@@ -253,7 +291,7 @@ func evalArray(array *Expression) (value interface{}, err error) {
 	values := []interface{}{}
 	// Single argument
 	if inner.Type != Args && inner.Type != EmptyExpression {
-		v, err := EvaluateExpression(inner)
+		v, err := EvaluateExpression(rt, inner)
 		if err != nil {
 			return value, err
 		}
@@ -264,7 +302,7 @@ func evalArray(array *Expression) (value interface{}, err error) {
 	// Multiple arguments
 	if inner.Type == Args {
 		for _, expr := range inner.Exprs {
-			v, err := EvaluateExpression(&expr)
+			v, err := EvaluateExpression(rt, &expr)
 			if err != nil {
 				return value, err
 			}
@@ -276,45 +314,169 @@ func evalArray(array *Expression) (value interface{}, err error) {
 	return &env.Array{Values: values, Length: len(values)}, err
 }
 
-func evalIndex(array *Expression) (value interface{}, err error) {
-	line := array.Line
-	arr, err := EvaluateExpression(array.Left)
+func evalIndex(rt *env.Runtime, array *Expression) (value interface{}, err error) {
+	arr, err := EvaluateExpression(rt, array.Left)
 	if err != nil {
 		return value, err
 	}
 
-	index, err := EvaluateExpression(array.Right)
+	index, err := EvaluateExpression(rt, array.Right)
 	if err != nil {
 		return value, err
 	}
 
+	// Maps are indexed by key
+	if m, ok := arr.(*env.Map); ok {
+		value, err = m.Get(index)
+		return value, diag.Locate(err, array.Span)
+	}
+
 	// Get index as integer. If not return error
 	indexInt, ok := util.IsInt(index)
 	if !ok {
-		return value, fmt.Errorf(ErrNotInteger.Error(), line)
+		return value, ErrNotInteger.At(array.Right.Span)
 	}
 
 	if a, ok := arr.(*env.Array); ok {
 		// Env handles getting index and errors for out of range etc
 		value, err = a.Get(indexInt)
 		if err != nil {
-			return value, fmt.Errorf(err.Error(), line)
+			return value, diag.Locate(err, array.Span)
 		}
 
 		return value, err
 	}
 
-	// Get string index
+	// Get string index. Strings are indexed by character, not byte
 	if s, ok := arr.(string); ok {
-		if indexInt > len(s) {
-			return value, env.ErrIndexOutOfRange
+		chars := []rune(s)
+		if indexInt < 0 {
+			indexInt += len(chars)
+		}
+
+		if indexInt >= len(chars) || indexInt < 0 {
+			return value, env.ErrIndexOutOfRange.At(array.Span)
 		}
 
-		return string(s[indexInt]), err
+		return string(chars[indexInt]), err
 	}
 
 	// arr is not array (or string)
-	return value, fmt.Errorf(env.ErrNotArray.Error(), util.GetType(arr), line)
+	return value, env.ErrNotArray.At(array.Left.Span, util.GetType(arr))
+}
+
+func evalSlice(rt *env.Runtime, slice *Expression) (value interface{}, err error) {
+	target, err := EvaluateExpression(rt, slice.Left)
+	if err != nil {
+		return value, err
+	}
+
+	length, chars := 0, []rune{}
+	switch t := target.(type) {
+	case *env.Array:
+		length = t.Length
+	case string:
+		chars = []rune(t)
+		length = len(chars)
+	default:
+		return value, env.ErrNotArray.At(slice.Left.Span, util.GetType(target))
+	}
+
+	// Left out and nil bounds use the default value
+	bounds := []*int{nil, nil, nil}
+	for i, expr := range slice.Exprs {
+		if expr.Type == EmptyExpression {
+			continue
+		}
+
+		v, err := EvaluateExpression(rt, &expr)
+		if err != nil {
+			return value, err
+		}
+
+		if v == nil {
+			continue
+		}
+
+		n, ok := util.IsInt(v)
+		if !ok {
+			return value, ErrNotInteger.At(expr.Span)
+		}
+
+		bounds[i] = &n
+	}
+
+	indices, err := sliceIndices(length, bounds[0], bounds[1], bounds[2])
+	if err != nil {
+		return value, diag.Locate(err, slice.Span)
+	}
+
+	if _, ok := target.(string); ok {
+		s := []rune{}
+		for _, i := range indices {
+			s = append(s, chars[i])
+		}
+
+		return string(s), err
+	}
+
+	values := []interface{}{}
+	for _, i := range indices {
+		values = append(values, target.(*env.Array).Values[i])
+	}
+
+	return env.NewArray(values), err
+}
+
+// Returns the indices picked by the slice. Start and end are clamped to the
+// length, and negative ones count from the end. With a negative step, start
+// and end default to the end and start of the array, going backwards.
+func sliceIndices(length int, start *int, end *int, step *int) (indices []int, err error) {
+	s := 1
+	if step != nil {
+		s = *step
+	}
+
+	if s == 0 {
+		return indices, ErrZeroStep
+	}
+
+	// Lowest and highest value a bound can be clamped to
+	low, high := 0, length
+	if s < 0 {
+		low, high = -1, length-1
+	}
+
+	bound := func(b *int, def int) int {
+		if b == nil {
+			return def
+		}
+
+		n := *b
+		if n < 0 {
+			n += length
+		}
+
+		if n < low {
+			return low
+		} else if n > high {
+			return high
+		}
+
+		return n
+	}
+
+	from, to := bound(start, 0), bound(end, length)
+	if s < 0 {
+		from, to = bound(start, length-1), bound(end, -1)
+	}
+
+	indices = []int{}
+	for i := from; (s > 0 && i < to) || (s < 0 && i > to); i += s {
+		indices = append(indices, i)
+	}
+
+	return indices, err
 }
 
 func isTruthy(value interface{}) bool {
@@ -322,5 +484,61 @@ func isTruthy(value interface{}) bool {
 }
 
 func isNumber(value interface{}) bool {
-	return util.GetType(value) == "number"
+	return env.IsNumber(value)
+}
+
+// Values of the embedded expressions are formatted the same way as print
+func evalInterpolation(rt *env.Runtime, interp *Expression) (value interface{}, err error) {
+	template := interp.Value.Literal.(lexer.Template)
+	var b strings.Builder
+	for i, text := range template.Strings {
+		b.WriteString(text)
+		if i == len(interp.Exprs) {
+			break
+		}
+
+		v, err := EvaluateExpression(rt, &interp.Exprs[i])
+		if err != nil {
+			return value, err
+		}
+
+		b.WriteString(util.FormatPrintValue(v))
+	}
+
+	return b.String(), err
+}
+
+// Only the chosen branch is evaluated
+func evalConditional(rt *env.Runtime, cond *Expression) (value interface{}, err error) {
+	condition, err := EvaluateExpression(rt, cond.Inner)
+	if err != nil {
+		return value, err
+	}
+
+	if isTruthy(condition) {
+		return EvaluateExpression(rt, cond.Left)
+	}
+
+	return EvaluateExpression(rt, cond.Right)
+}
+
+func evalMap(rt *env.Runtime, m *Expression) (value interface{}, err error) {
+	values := env.NewMap()
+	for _, pair := range m.Exprs {
+		key, err := EvaluateExpression(rt, pair.Left)
+		if err != nil {
+			return value, err
+		}
+
+		val, err := EvaluateExpression(rt, pair.Right)
+		if err != nil {
+			return value, err
+		}
+
+		if err = values.Set(key, val); err != nil {
+			return value, diag.Locate(err, pair.Left.Span)
+		}
+	}
+
+	return values, err
 }
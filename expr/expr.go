@@ -1,30 +1,36 @@
 package expr
 
 import (
-	"errors"
-
+	"github.com/jesperkha/Fizz/diag"
+	"github.com/jesperkha/Fizz/env"
 	"github.com/jesperkha/Fizz/lexer"
 )
 
 var (
-	ErrParenError           = errors.New("unmatched parenthesies, line %d")
-	ErrBracketError         = errors.New("unmatched brackets, line %d")
-	ErrInvalidUnaryOperator = errors.New("invalid unary operator '%s', line %d")
-	ErrInvalidOperatorType  = errors.New("invalid operator '%s' for type %s, line %d")
-	ErrInvalidOperatorTypes = errors.New("invalid operator '%s' for types %s and %s, line %d")
-	ErrDivideByZero         = errors.New("division by 0, line %d")
-	ErrNoExpression         = errors.New("empty expression, line %d")
-	ErrInvalidExpression    = errors.New("invalid expression, line %d")
-	ErrExpectedExpression   = errors.New("expected expression in group, line %d")
-	ErrNotInteger           = errors.New("index must be integer, line %d")
-	ErrCommaError           = errors.New("comma error, line %d")
-	ErrIncorrectArgs        = errors.New("%s() expected %d args, got %d, line %d")
-	ErrNotFunction          = errors.New("type %s is not a function, line %d")
-	ErrNilValueError        = errors.New("unexpected nil value in expression, line %d")
-	ErrNotObject            = errors.New("type %s has no attributes, line %d")
-	ErrInvalidType          = errors.New("expr: unknown expression type, line %d")
-	ErrExpectedName         = errors.New("expected name after dot, line %d")
-	ErrIllegalType          = errors.New("unknown type '%s'")
+	ErrParenError           = diag.New(diag.SyntaxError, "E111", "unmatched parenthesies")
+	ErrBracketError         = diag.New(diag.SyntaxError, "E112", "unmatched brackets")
+	ErrNoExpression         = diag.New(diag.SyntaxError, "E113", "empty expression")
+	ErrInvalidExpression    = diag.New(diag.SyntaxError, "E114", "invalid expression")
+	ErrExpectedExpression   = diag.New(diag.SyntaxError, "E115", "expected expression in group")
+	ErrCommaError           = diag.New(diag.SyntaxError, "E116", "comma error")
+	ErrExpectedName         = diag.New(diag.SyntaxError, "E117", "expected name after dot")
+	ErrInvalidUnaryOperator = diag.New(diag.TypeError, "E201", "invalid unary operator '%s'")
+	ErrInvalidOperatorType  = diag.New(diag.TypeError, "E202", "invalid operator '%s' for type %s")
+	ErrInvalidOperatorTypes = diag.New(diag.TypeError, "E203", "invalid operator '%s' for types %s and %s")
+	ErrDivideByZero         = diag.New(diag.RuntimeError, "E204", "division by 0")
+	ErrNotInteger           = diag.New(diag.TypeError, "E205", "index must be integer")
+	ErrIncorrectArgs        = diag.New(diag.TypeError, "E206", "%s() expected %d args, got %d")
+	ErrNotFunction          = diag.New(diag.TypeError, "E207", "type %s is not a function")
+	ErrNilValueError        = diag.New(diag.TypeError, "E208", "unexpected nil value in expression")
+	ErrNotObject            = diag.New(diag.TypeError, "E209", "type %s has no attributes")
+	ErrInvalidType          = diag.New(diag.RuntimeError, "E210", "expr: unknown expression type")
+	ErrIllegalType          = diag.New(diag.TypeError, "E211", "unknown type '%s'")
+	ErrInvalidMapEntry      = diag.New(diag.SyntaxError, "E118", "expected key: value in map literal")
+	ErrInvalidConditional   = diag.New(diag.SyntaxError, "E119", "expected condition ? value : value")
+	ErrInvalidSlice         = diag.New(diag.SyntaxError, "E120", "expected start:end:step in slice")
+	ErrZeroStep             = diag.New(diag.RuntimeError, "E212", "slice step cannot be 0")
+	ErrExpectedIntegers     = diag.New(diag.TypeError, "E213", "operator '%s' expects integers, got %s")
+	ErrNegativeShift        = diag.New(diag.RuntimeError, "E214", "shift count must be a non-negative integer")
 )
 
 const (
@@ -39,11 +45,26 @@ const (
 	Getter
 	Array
 	Index
+	Lambda
+	Map
+	Pair
+	Conditional
+	Slice
+	Interpolation
+)
+
+// Function literals contain statements, so they are parsed and created by the
+// stmt package, which this package cannot import. The stmt package sets these
+// when it is loaded. The parsed function is kept in the Lambda field of the
+// expression.
+var (
+	ParseLambda func(tokens []lexer.Token) (lambda interface{}, err error)
+	EvalLambda  func(rt *env.Runtime, lambda interface{}) (value interface{}, err error)
 )
 
+// Expression span covers all tokens the expression was parsed from.
 type Expression struct {
 	Type    int
-	Line    int
 	Name    string
 	Operand lexer.Token
 	Value   lexer.Token
@@ -51,4 +72,6 @@ type Expression struct {
 	Right   *Expression
 	Inner   *Expression
 	Exprs   []Expression
+	Lambda  interface{}
+	diag.Span
 }
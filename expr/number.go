@@ -0,0 +1,254 @@
+package expr
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/jesperkha/Fizz/env"
+	"github.com/jesperkha/Fizz/lexer"
+)
+
+const minInt = -int(^uint(0)>>1) - 1
+
+// Reports whether the operator can be used with two numbers
+func isNumberOperator(op int) bool {
+	switch op {
+	case lexer.PLUS, lexer.MINUS, lexer.STAR, lexer.SLASH, lexer.MODULO, lexer.HAT,
+		lexer.GREATER, lexer.LESS, lexer.GREATER_EQUAL, lexer.LESS_EQUAL:
+		return true
+	}
+
+	return false
+}
+
+// Reports whether the operator can only be used with two integers
+func isIntegerOperator(op int) bool {
+	switch op {
+	case lexer.BIT_AND, lexer.BIT_OR, lexer.BIT_XOR, lexer.SHIFT_LEFT, lexer.SHIFT_RIGHT, lexer.FLOOR_DIV:
+		return true
+	}
+
+	return false
+}
+
+// Evaluates an arithmetic or comparison operator on two numbers. If both are
+// integers the result is an exact integer, which becomes a big integer
+// instead of overflowing. Dividing two integers gives an integer if the
+// division is exact, and a float if not. A negative power of an integer is
+// also a float. If either number is a float, both are used as floats.
+func Arithmetic(op int, left interface{}, right interface{}) (value interface{}, err error) {
+	if env.IsInteger(left) && env.IsInteger(right) {
+		return intArithmetic(op, left, right)
+	}
+
+	a, _ := env.ToFloat(left)
+	b, _ := env.ToFloat(right)
+	switch op {
+	case lexer.PLUS:
+		return a + b, err
+	case lexer.MINUS:
+		return a - b, err
+	case lexer.STAR:
+		return a * b, err
+	case lexer.HAT:
+		return math.Pow(a, b), err
+	case lexer.GREATER:
+		return a > b, err
+	case lexer.LESS:
+		return a < b, err
+	case lexer.LESS_EQUAL:
+		return a <= b, err
+	case lexer.GREATER_EQUAL:
+		return a >= b, err
+	case lexer.MODULO:
+		if b == 0 {
+			return nil, ErrDivideByZero
+		}
+		return math.Mod(a, b), err
+	case lexer.SLASH:
+		if b == 0 {
+			return nil, ErrDivideByZero
+		}
+		return a / b, err
+	}
+
+	return nil, ErrInvalidExpression
+}
+
+func intArithmetic(op int, left interface{}, right interface{}) (value interface{}, err error) {
+	// Most integers fit in an int, so only use big integers on overflow
+	if x, ok := left.(int); ok {
+		if y, ok := right.(int); ok {
+			if value, ok := smallArithmetic(op, x, y); ok {
+				return value, err
+			}
+		}
+	}
+
+	switch op {
+	case lexer.GREATER:
+		return env.CompareNumbers(left, right) > 0, err
+	case lexer.LESS:
+		return env.CompareNumbers(left, right) < 0, err
+	case lexer.LESS_EQUAL:
+		return env.CompareNumbers(left, right) <= 0, err
+	case lexer.GREATER_EQUAL:
+		return env.CompareNumbers(left, right) >= 0, err
+	}
+
+	a, _ := env.ToBig(left)
+	b, _ := env.ToBig(right)
+	switch op {
+	case lexer.PLUS:
+		return env.FromBig(a.Add(a, b)), err
+	case lexer.MINUS:
+		return env.FromBig(a.Sub(a, b)), err
+	case lexer.STAR:
+		return env.FromBig(a.Mul(a, b)), err
+	case lexer.HAT:
+		if b.Sign() < 0 {
+			x, _ := env.ToFloat(left)
+			y, _ := env.ToFloat(right)
+			return math.Pow(x, y), err
+		}
+		return env.FromBig(a.Exp(a, b, nil)), err
+	case lexer.MODULO:
+		if b.Sign() == 0 {
+			return nil, ErrDivideByZero
+		}
+		return env.FromBig(a.Rem(a, b)), err
+	case lexer.SLASH:
+		if b.Sign() == 0 {
+			return nil, ErrDivideByZero
+		}
+
+		q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+		if r.Sign() == 0 {
+			return env.FromBig(q), err
+		}
+
+		f, _ := new(big.Float).Quo(new(big.Float).SetInt(a), new(big.Float).SetInt(b)).Float64()
+		return f, err
+	}
+
+	return nil, ErrInvalidExpression
+}
+
+// Evaluates the operator on two ints. Ok is false if the result does not
+// fit in an int, or if the operator is not handled here.
+func smallArithmetic(op int, x int, y int) (value interface{}, ok bool) {
+	switch op {
+	case lexer.PLUS:
+		r := x + y
+		return r, (r > x) == (y > 0)
+	case lexer.MINUS:
+		r := x - y
+		return r, (r < x) == (y > 0)
+	case lexer.STAR:
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+
+		r := x * y
+		return r, r/y == x && !(x == -1 && y == minInt) && !(y == -1 && x == minInt)
+	case lexer.GREATER:
+		return x > y, true
+	case lexer.LESS:
+		return x < y, true
+	case lexer.LESS_EQUAL:
+		return x <= y, true
+	case lexer.GREATER_EQUAL:
+		return x >= y, true
+	}
+
+	return value, false
+}
+
+// Evaluates a bitwise operator or floor division on two integers. Bitwise
+// operators work as if negative integers were in two's complement. Floor
+// division rounds down, so -7 // 2 is -4. Shifting left never overflows.
+func IntegerOperation(op int, left interface{}, right interface{}) (value interface{}, err error) {
+	x, xSmall := left.(int)
+	y, ySmall := right.(int)
+	if xSmall && ySmall {
+		switch op {
+		case lexer.BIT_AND:
+			return x & y, err
+		case lexer.BIT_OR:
+			return x | y, err
+		case lexer.BIT_XOR:
+			return x ^ y, err
+		}
+	}
+
+	a, _ := env.ToBig(left)
+	b, _ := env.ToBig(right)
+	switch op {
+	case lexer.BIT_AND:
+		return env.FromBig(a.And(a, b)), err
+	case lexer.BIT_OR:
+		return env.FromBig(a.Or(a, b)), err
+	case lexer.BIT_XOR:
+		return env.FromBig(a.Xor(a, b)), err
+	case lexer.SHIFT_LEFT, lexer.SHIFT_RIGHT:
+		if !ySmall || y < 0 {
+			return nil, ErrNegativeShift
+		}
+
+		if op == lexer.SHIFT_LEFT {
+			return env.FromBig(a.Lsh(a, uint(y))), err
+		}
+		return env.FromBig(a.Rsh(a, uint(y))), err
+	case lexer.FLOOR_DIV:
+		if b.Sign() == 0 {
+			return nil, ErrDivideByZero
+		}
+
+		// Quo rounds towards zero, so round down when the result is negative
+		q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+		if r.Sign() != 0 && r.Sign() != b.Sign() {
+			q.Sub(q, big.NewInt(1))
+		}
+		return env.FromBig(q), err
+	}
+
+	return nil, ErrInvalidExpression
+}
+
+// Returns the bitwise complement of the integer, which is -n - 1
+func bitNot(value interface{}) interface{} {
+	if n, ok := value.(int); ok {
+		return ^n
+	}
+
+	b, _ := env.ToBig(value)
+	return env.FromBig(b.Not(b))
+}
+
+// Returns the type of the value for errors about integer operators. Floats
+// are named as such since integers are numbers too.
+func typeName(value interface{}) string {
+	if _, ok := value.(float64); ok {
+		return "float"
+	}
+
+	return env.TypeOf(value)
+}
+
+// Returns the negated number. Negating the smallest int gives a big integer.
+func negate(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		if v == minInt {
+			b, _ := env.ToBig(v)
+			return b.Neg(b)
+		}
+		return -v
+	case *big.Int:
+		return env.FromBig(new(big.Int).Neg(v))
+	case float64:
+		return -v
+	}
+
+	return value
+}
@@ -1,11 +1,19 @@
 package expr
 
 import (
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/lexer"
 	"github.com/jesperkha/Fizz/util"
 )
 
+// Parses tokens into expression tree. Errors are given the span of the
+// innermost expression they were raised in.
 func ParseExpression(tokens []lexer.Token) (expr Expression, err error) {
+	expr, err = parseExpression(tokens)
+	return expr, diag.Locate(err, util.GetSpan(tokens))
+}
+
+func parseExpression(tokens []lexer.Token) (expr Expression, err error) {
 	if len(tokens) == 0 {
 		return Expression{Type: EmptyExpression}, err
 	}
@@ -39,18 +47,24 @@ func ParseExpression(tokens []lexer.Token) (expr Expression, err error) {
 		return expr, ErrBracketError
 	}
 
-	line := tokens[0].Line
+	span := util.GetSpan(tokens)
 
 	if len(tokens) == 1 {
+		// INTERPOLATED STRING
+		// The embedded expressions were lexed with the string
+		if tokens[0].Type == lexer.TEMPLATE {
+			return parseInterpolation(tokens[0], span)
+		}
+
 		// VARIABLE
 		// Variables have a different expression type
 		if tokens[0].Type == lexer.IDENTIFIER {
-			return Expression{Type: Variable, Name: tokens[0].Lexeme, Line: line}, err
+			return Expression{Type: Variable, Name: tokens[0].Lexeme, Span: span}, err
 		}
 
 		// LITERAL
 		// Only other option is a literal, the only error this can cause is an undefined variable
-		return Expression{Type: Literal, Value: tokens[0], Line: line}, err
+		return Expression{Type: Literal, Value: tokens[0], Span: span}, err
 	}
 
 	// ARGUMENTS
@@ -65,15 +79,32 @@ func ParseExpression(tokens []lexer.Token) (expr Expression, err error) {
 			args = append(args, arg)
 		}
 
-		return Expression{Type: Args, Exprs: args, Line: line}, err
+		return Expression{Type: Args, Exprs: args, Span: span}, err
+	}
+
+	// CONDITIONAL
+	// Has the lowest precedence, so the first question mark is the split point. This makes
+	// conditionals in the else branch nest without parens: a ? b : c ? d : e
+	question := -1
+	util.SeekBreakPoint(tokens, func(i int, t lexer.Token) bool {
+		if t.Type == lexer.QUESTION && question == -1 {
+			question = i
+		}
+		return false
+	})
+
+	if question != -1 {
+		return parseConditional(tokens, question, span)
 	}
 
 	// UNARY
 	// Check if first token is a valid unary token type
-	unaryOperators := []int{lexer.MINUS, lexer.TYPE, lexer.NOT}
-	if util.Contains(unaryOperators, tokens[0].Type) && (len(tokens) == 2 || tokens[1].Type == lexer.LEFT_PAREN) {
+	unaryOperators := []int{lexer.MINUS, lexer.TYPE, lexer.NOT, lexer.BIT_NOT}
+	lambdaEnd, isLambda := util.SeekFunctionLiteral(tokens, 1)
+	isLambda = isLambda && lambdaEnd == len(tokens)-1
+	if util.Contains(unaryOperators, tokens[0].Type) && (len(tokens) == 2 || tokens[1].Type == lexer.LEFT_PAREN || isLambda) {
 		right, err := ParseExpression(tokens[1:])
-		return Expression{Type: Unary, Right: &right, Operand: tokens[0], Line: line}, err
+		return Expression{Type: Unary, Right: &right, Operand: tokens[0], Span: span}, err
 	}
 
 	// BINARY
@@ -81,23 +112,23 @@ func ParseExpression(tokens []lexer.Token) (expr Expression, err error) {
 	// Only check if not in a group. Then check if valid operator, skip if not.
 	lowest, lowestIdx := lexer.Token{Type: 999}, 0
 	util.SeekBreakPoint(tokens, func(i int, t lexer.Token) bool {
-		// Checks if last token as the same, (1 - -1), and ignores if so. This means the target will
-		// always be the last splittable token: (10 / 4 * 2) splits at *
-		if t.Type <= lowest.Type && i != 0 && tokens[i-1].Type != t.Type {
+		// Checks if last token is an operator, (1 + -1), and ignores if so since it is then a
+		// unary operator. This means the target will always be the last splittable token with
+		// the lowest precedence: (10 / 4 * 2) splits at *
+		if lexer.Precedence(t.Type) <= lexer.Precedence(lowest.Type) && i != 0 && !isBinaryOperator(tokens[i-1].Type) {
 			lowest, lowestIdx = t, i
 		}
 		return false
 	})
 
-	t := lowest.Type
-	if t >= lexer.AND && t <= lexer.HAT {
+	if isBinaryOperator(lowest.Type) {
 		left, err := ParseExpression(tokens[:lowestIdx])
 		if err != nil {
 			return expr, err
 		}
 
 		right, err := ParseExpression(tokens[lowestIdx+1:])
-		return Expression{Type: Binary, Left: &left, Right: &right, Operand: tokens[lowestIdx], Line: line}, err
+		return Expression{Type: Binary, Left: &left, Right: &right, Operand: tokens[lowestIdx], Span: span}, err
 	}
 
 	// GROUP
@@ -107,7 +138,7 @@ func ParseExpression(tokens []lexer.Token) (expr Expression, err error) {
 	endIdx, _ := util.SeekClosingBracket(tokens, 0, lexer.LEFT_PAREN, lexer.RIGHT_PAREN)
 	if tokens[0].Type == lexer.LEFT_PAREN && endIdx == len(tokens)-1 {
 		inner, err := ParseExpression(tokens[1 : len(tokens)-1])
-		return Expression{Type: Group, Inner: &inner, Line: line}, err
+		return Expression{Type: Group, Inner: &inner, Span: span}, err
 	}
 
 	// ARRAY LITERAL
@@ -115,51 +146,79 @@ func ParseExpression(tokens []lexer.Token) (expr Expression, err error) {
 	endIdx, _ = util.SeekClosingBracket(tokens, 0, lexer.LEFT_SQUARE, lexer.RIGHT_SQUARE)
 	if tokens[0].Type == lexer.LEFT_SQUARE && endIdx == len(tokens)-1 {
 		inner, err := ParseExpression(tokens[1 : len(tokens)-1])
-		return Expression{Type: Array, Inner: &inner, Line: line}, err
+		return Expression{Type: Array, Inner: &inner, Span: span}, err
+	}
+
+	// MAP LITERAL
+	// Entries are split by comma and the key and value by colon. Values using the
+	// colon 'or' operator must be put in parens.
+	endIdx, _ = util.SeekClosingBracket(tokens, 0, lexer.LEFT_BRACE, lexer.RIGHT_BRACE)
+	if tokens[0].Type == lexer.LEFT_BRACE && endIdx == len(tokens)-1 {
+		return parseMap(tokens[1:len(tokens)-1], span)
+	}
+
+	// FUNCTION LITERAL
+	// Anonymous function, func (params) { body }. The body is parsed by the stmt package.
+	endIdx, ok := util.SeekFunctionLiteral(tokens, 0)
+	if ok && endIdx == len(tokens)-1 {
+		lambda, err := ParseLambda(tokens)
+		return Expression{Type: Lambda, Lambda: lambda, Span: span}, err
 	}
 
 	// ARRAY GETTER
-	// Array index getter has same priority as call
+	// Array index getter has same priority as call, so the last one of them is parsed first
 	targetIndex, eofIndex := util.SeekBreakPoint(tokens, func(i int, t lexer.Token) bool {
 		return t.Type == lexer.LEFT_SQUARE
 	})
 
+	targetCall, eofCall := util.SeekBreakPoint(tokens, func(i int, t lexer.Token) bool {
+		return t.Type == lexer.LEFT_PAREN
+	})
+
 	// Also check where the closest dot is. If a dot comes after the last call, it should be parsed as a getter.
 	// The only other option is for the call to be last (or error).
 	targetDot, eofDot := util.SeekBreakPoint(tokens, func(i int, t lexer.Token) bool {
-		return t.Type == lexer.DOT
+		return t.Type == lexer.DOT || t.Type == lexer.SAFE_DOT
 	})
 
-	if !eofIndex && targetIndex > targetDot {
+	if !eofIndex && targetIndex > targetDot && (eofCall || targetIndex > targetCall) {
 		array, err := ParseExpression(tokens[:targetIndex])
 		if err != nil {
 			return expr, err
 		}
 
 		endIdx, _ := util.SeekClosingBracket(tokens, targetIndex, lexer.LEFT_SQUARE, lexer.RIGHT_SQUARE)
-		arg, err := ParseExpression(tokens[targetIndex+1 : endIdx])
-		return Expression{Type: Index, Left: &array, Right: &arg, Line: line}, err
+		inner := tokens[targetIndex+1 : endIdx]
+
+		// Colons in the index make it a slice, unless they are part of a conditional
+		_, noConditional := util.SeekBreakPoint(inner, func(i int, t lexer.Token) bool {
+			return t.Type == lexer.QUESTION
+		})
+
+		if parts := util.SplitByToken(inner, lexer.OR); len(parts) > 1 && noConditional {
+			return parseSlice(array, parts, span)
+		}
+
+		arg, err := ParseExpression(inner)
+		return Expression{Type: Index, Left: &array, Right: &arg, Span: span}, err
 	}
 
 	// FUNCTION CALL
 	// Search for left paren, then parse the left part of the expression. Also parse the args of the caller.
 	// Start cannot be set to 0 in loop because that would be a group expression
-	targetCall, eofCall := util.SeekBreakPoint(tokens, func(i int, t lexer.Token) bool {
-		return t.Type == lexer.LEFT_PAREN
-	})
-
-	if !eofCall && targetCall > targetDot {
+	if !eofCall && targetCall > targetDot && targetCall != 0 {
 		callee, err := ParseExpression(tokens[:targetCall])
 		if err != nil {
 			return expr, err
 		}
 
 		args, err := ParseExpression(tokens[targetCall:])
-		return Expression{Type: Call, Left: &callee, Inner: &args, Line: line}, err
+		return Expression{Type: Call, Left: &callee, Inner: &args, Span: span}, err
 	}
 
 	// OBJECT GETTER
-	// Splits by dot and parses the left side recursively
+	// Splits by dot and parses the left side recursively. The dot is kept as the operand
+	// since the safe dot, ?., gives nil instead of failing for nil objects.
 	if !eofDot {
 		left, err := ParseExpression(tokens[:targetDot])
 		if err != nil {
@@ -171,8 +230,113 @@ func ParseExpression(tokens []lexer.Token) (expr Expression, err error) {
 			return expr, ErrExpectedName
 		}
 
-		return Expression{Type: Getter, Left: &left, Right: &right, Line: line}, err
+		return Expression{Type: Getter, Left: &left, Right: &right, Operand: tokens[targetDot], Span: span}, err
 	}
 
 	return expr, ErrInvalidExpression
 }
+
+// Parses conditional expression split at the question mark. The branches are split
+// at the colon matching the question mark, so conditionals can be nested in the
+// first branch too. Any colons after that are 'or' operators in the second branch.
+func parseConditional(tokens []lexer.Token, question int, span diag.Span) (expr Expression, err error) {
+	colon, nested := -1, 0
+	util.SeekBreakPoint(tokens[question+1:], func(i int, t lexer.Token) bool {
+		switch {
+		case colon != -1:
+		case t.Type == lexer.QUESTION:
+			nested++
+		case t.Type == lexer.OR && nested > 0:
+			nested--
+		case t.Type == lexer.OR:
+			colon = question + 1 + i
+		}
+		return false
+	})
+
+	if question == 0 || colon == -1 || colon == question+1 || colon == len(tokens)-1 {
+		return expr, ErrInvalidConditional
+	}
+
+	condition, err := ParseExpression(tokens[:question])
+	if err != nil {
+		return expr, err
+	}
+
+	then, err := ParseExpression(tokens[question+1 : colon])
+	if err != nil {
+		return expr, err
+	}
+
+	otherwise, err := ParseExpression(tokens[colon+1:])
+	return Expression{Type: Conditional, Inner: &condition, Left: &then, Right: &otherwise, Span: span}, err
+}
+
+// Parses the embedded expressions of a string. The text around them is kept in
+// the template of the string token.
+func parseInterpolation(token lexer.Token, span diag.Span) (expr Expression, err error) {
+	exprs := []Expression{}
+	for _, tokens := range token.Literal.(lexer.Template).Exprs {
+		e, err := ParseExpression(tokens)
+		if err != nil {
+			return expr, err
+		}
+
+		exprs = append(exprs, e)
+	}
+
+	return Expression{Type: Interpolation, Value: token, Exprs: exprs, Span: span}, err
+}
+
+// Parses the start, end, and step of a slice. Left out values are empty expressions.
+func parseSlice(array Expression, parts [][]lexer.Token, span diag.Span) (expr Expression, err error) {
+	if len(parts) > 3 {
+		return expr, ErrInvalidSlice
+	}
+
+	bounds := []Expression{}
+	for _, part := range parts {
+		bound, err := ParseExpression(part)
+		if err != nil {
+			return expr, err
+		}
+
+		bounds = append(bounds, bound)
+	}
+
+	return Expression{Type: Slice, Left: &array, Exprs: bounds, Span: span}, err
+}
+
+// Parses the entries of a map literal into key value pairs
+func parseMap(tokens []lexer.Token, span diag.Span) (expr Expression, err error) {
+	pairs := []Expression{}
+	if len(tokens) == 0 {
+		return Expression{Type: Map, Exprs: pairs, Span: span}, err
+	}
+
+	for _, entry := range util.SplitByToken(tokens, lexer.COMMA) {
+		split := util.SplitByToken(entry, lexer.OR)
+		if len(split) != 2 || len(split[0]) == 0 || len(split[1]) == 0 {
+			return expr, ErrInvalidMapEntry.At(util.GetSpan(entry))
+		}
+
+		key, err := ParseExpression(split[0])
+		if err != nil {
+			return expr, err
+		}
+
+		value, err := ParseExpression(split[1])
+		if err != nil {
+			return expr, err
+		}
+
+		pairs = append(pairs, Expression{Type: Pair, Left: &key, Right: &value, Span: util.GetSpan(entry)})
+	}
+
+	return Expression{Type: Map, Exprs: pairs, Span: span}, err
+}
+
+// Reports whether the token type is a binary operator
+func isBinaryOperator(tokenType int) bool {
+	return tokenType >= lexer.NIL_COALESCE && tokenType <= lexer.HAT
+}
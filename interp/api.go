@@ -0,0 +1,107 @@
+package interp
+
+import (
+	"errors"
+
+	"github.com/jesperkha/Fizz/diag"
+	"github.com/jesperkha/Fizz/env"
+	"github.com/jesperkha/Fizz/expr"
+	"github.com/jesperkha/Fizz/stmt"
+)
+
+// Public API for embedding Fizz in Go programs. Values passed in are converted
+// to Fizz values with env.ToFizz, and values passed out are converted back to
+// plain Go values with env.ToGo.
+
+var (
+	ErrNotCallable = diag.New(diag.TypeError, "E304", "'%s' is not a function")
+)
+
+// Evaluates code in the global environment of the interpreter. Unlike
+// Interperate, the environment is kept after the run, so globals can be read
+// with Get and functions declared in the code can be called with Call.
+func (in *Interpreter) Eval(code string) error {
+	return in.execute("", code)
+}
+
+// Sets a global variable to the given Go value. The variable is declared if
+// it does not exist already. Globals set before a run are available to the
+// program as normal variables.
+func (in *Interpreter) Set(name string, value interface{}) error {
+	v, err := env.ToFizz(value)
+	if err != nil {
+		return err
+	}
+
+	if in.runtime.Assign(name, v) != nil {
+		return in.runtime.Declare(name, v)
+	}
+
+	return nil
+}
+
+// Gets the value of a global variable converted to a Go value.
+func (in *Interpreter) Get(name string) (value interface{}, err error) {
+	v, err := in.runtime.Get(name)
+	if err != nil {
+		return value, err
+	}
+
+	return env.ToGo(v), err
+}
+
+// Calls a Fizz function with the given Go arguments and returns the result
+// as a Go value. The call is pushed to the callstack like calls made in Fizz,
+// but has no call site, so its frame is given the file the function was
+// declared in and no position.
+func (in *Interpreter) Call(f *env.Callable, args ...interface{}) (value interface{}, err error) {
+	if len(args) != f.NumArgs && f.NumArgs != -1 {
+		return value, expr.ErrIncorrectArgs.With(f.Name, f.NumArgs, len(args))
+	}
+
+	fizzArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		if fizzArgs[i], err = env.ToFizz(arg); err != nil {
+			return value, err
+		}
+	}
+
+	in.runtime.ResetCallstack()
+	frame := env.Frame{Name: f.Name, File: f.Origin, Args: fizzArgs}
+	if err = in.runtime.PushCall(frame); err != nil {
+		return value, err
+	}
+
+	value, err = f.Call(fizzArgs...)
+	in.runtime.PopCall(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return env.ToGo(value), err
+}
+
+// Returns the value given to the error statement that raised err, converted
+// to a Go value. Ok is false if err was not raised by an error statement.
+func ErrorValue(err error) (value interface{}, ok bool) {
+	var d *diag.Error
+	if errors.As(err, &d) && errors.Is(d, stmt.ErrRaised) {
+		return env.ToGo(d.Value), true
+	}
+
+	return value, false
+}
+
+// Calls the global function with the given name. See Call.
+func (in *Interpreter) CallName(name string, args ...interface{}) (value interface{}, err error) {
+	v, err := in.runtime.Get(name)
+	if err != nil {
+		return value, err
+	}
+
+	if f, ok := v.(*env.Callable); ok {
+		return in.Call(f, args...)
+	}
+
+	return value, ErrNotCallable.With(name)
+}
@@ -1,11 +1,12 @@
 package interp
 
 import (
-	"errors"
 	"fmt"
+	"math/big"
 	"os"
 	"strings"
 
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/env"
 	"github.com/jesperkha/Fizz/lexer"
 	"github.com/jesperkha/Fizz/lib"
@@ -14,14 +15,109 @@ import (
 )
 
 var (
-	ErrFileNotFound   = errors.New("cannot find file with name: '%s'")
-	ErrNonFizzFile    = errors.New("cannot run non-Fizz file")
-	ErrCircularImport = errors.New("circular import not allowed, %s <-> %s")
+	ErrFileNotFound   = diag.New(diag.ImportError, "E301", "cannot find file with name: '%s'")
+	ErrNonFizzFile    = diag.New(diag.ImportError, "E302", "cannot run non-Fizz file")
+	ErrCircularImport = diag.New(diag.ImportError, "E303", "circular import not allowed, %s <-> %s")
 )
 
-// Stores import pairs to check for import cycles. Duplicate erntries indicate
-// a circular import and an error is raised.
-var importPairs = util.UniquePairs{}
+// Interpreter runs Fizz programs. All state for a program, like variables,
+// the callstack, and imported files, is kept in the interpreter, so several
+// interpreters can be used at the same time, even from different goroutines.
+// A single interpreter is not safe for concurrent use.
+type Interpreter struct {
+	runtime *env.Runtime
+
+	// Stores import pairs to check for import cycles. Duplicate erntries indicate
+	// a circular import and an error is raised.
+	importPairs util.UniquePairs
+
+	// Source code of each file run, used to show the source line in errors
+	sources map[string]string
+}
+
+// Creates a new interpreter with an empty global environment.
+func New() *Interpreter {
+	return &Interpreter{
+		runtime:     env.NewRuntime(),
+		importPairs: util.UniquePairs{},
+		sources:     map[string]string{},
+	}
+}
+
+// Keeps the global environment between runs when set to false. Used by the
+// terminal to remember variables between inputs.
+func (in *Interpreter) SetThrowEnvironment(throw bool) {
+	in.runtime.ThrowEnvironment = throw
+}
+
+// Sets the max number of function calls that can be on the callstack at once.
+// Going past it raises an error with the chain of recursive calls. The default
+// depth is 1000.
+func (in *Interpreter) SetMaxDepth(depth int) {
+	in.runtime.MaxRecursionDepth = depth
+}
+
+// Returns the source code of the last file run with the given filename. The
+// filename for code not run from a file is an empty string.
+func (in *Interpreter) Source(filename string) string {
+	return in.sources[filename]
+}
+
+// Returns print ready format of the callstack for the last error. Each line
+// shows the function called with its arguments and the call site.
+func (in *Interpreter) GetCallstack() string {
+	lines := []string{}
+	for _, f := range in.Callstack() {
+		call := fmt.Sprintf("%s(%s)", f.Function, strings.Join(f.Args, ", "))
+		lines = append(lines, fmt.Sprintf("\tat %s in %s:%d:%d", call, f.File, f.Line, f.Column))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Returns the frames of the callstack for the last error, innermost first.
+func (in *Interpreter) Callstack() []diag.Frame {
+	frames := []diag.Frame{}
+	for _, f := range in.runtime.Callstack() {
+		args := []string{}
+		for _, arg := range f.Args {
+			args = append(args, formatArg(arg))
+		}
+
+		frames = append(frames, diag.Frame{
+			Function: f.Name,
+			File:     f.File,
+			Line:     f.Span.Line,
+			Column:   f.Span.Column,
+			Args:     args,
+		})
+	}
+
+	return frames
+}
+
+// Formats argument value for the callstack. Objects and arrays are shown by
+// their type to keep the callstack on one line per frame.
+func formatArg(arg interface{}) string {
+	switch arg.(type) {
+	case string:
+		return fmt.Sprintf("%q", arg)
+	case int, *big.Int, float64, bool, nil, *env.Callable:
+		return util.FormatPrintValue(arg)
+	}
+
+	return util.GetType(arg)
+}
+
+// Interperates string of code in a new interpreter. See Interpreter.Interperate.
+func Interperate(filename string, input string) (e env.Environment, err error) {
+	return New().Interperate(filename, input)
+}
+
+// Runs a fizz file in a new interpreter. See Interpreter.RunFile.
+func RunFile(filename string) (e env.Environment, err error) {
+	return New().RunFile(filename)
+}
 
 // Interperates string of code. The string is tokenized in the lexer package
 // where each token has a type, line, lexeme, and literal value. The parsed
@@ -37,29 +133,38 @@ var importPairs = util.UniquePairs{}
 // are evaluated. Variable values are also assigned and manipulated in the
 // variable environment found in the env package.
 
-func Interperate(filename string, input string) (e env.Environment, err error) {
+func (in *Interpreter) Interperate(filename string, input string) (e env.Environment, err error) {
+	err = in.execute(filename, input)
+	return in.runtime.NewEnvironment(), util.WrapFilename(filename, err)
+}
+
+// Runs all the steps described above in the current global environment.
+func (in *Interpreter) execute(filename string, input string) (err error) {
+	in.sources[filename] = input
+	in.runtime.ResetCallstack()
+
 	// Parses input characters into lexical tokens for single and double symbols,
 	// identifiers, and keywords.
 	lexicalTokens, err := lexer.GetTokens(input)
 	if err != nil {
-		return e, err
+		return err
 	}
 
 	// Lexical tokens are analysed and put into statement tokens. These statements
 	// contain all the information they need for execution and error handling.
 	statements, err := stmt.ParseStatements(lexicalTokens)
 	if err != nil {
-		return e, err
+		return err
 	}
 
 	// File imports are handled after parsing the statements, not when executing them.
 	// This means that all imports are run before anything else; they are "hoisted".
 	// Even declaring a variable with the same name as the file before importing it will
 	// raise an error as the file is imported before the variable is created.
-	includes := []string{}
+	includes := []stmt.Statement{}
 	for _, s := range statements {
 		if s.Type == stmt.Include {
-			includes = append(includes, s.Name)
+			includes = append(includes, s)
 		}
 
 		if s.Type != stmt.Import {
@@ -68,59 +173,68 @@ func Interperate(filename string, input string) (e env.Environment, err error) {
 
 		// Checks for circular imports. Add() returns true if the pair already exists.
 		name := util.GetPlainFilename(s.Name)
-		if this := util.GetPlainFilename(filename); importPairs.Add(name, this) {
-			return e, fmt.Errorf(ErrCircularImport.Error(), name, this)
+		if this := util.GetPlainFilename(filename); in.importPairs.Add(name, this) {
+			return ErrCircularImport.At(s.Span, name, this)
 		}
 
-		e, err = RunFile(s.Name + ".fizz")
+		// The file is run in its own global environment, so it does not see or
+		// replace the globals of the file importing it
+		in.runtime.PushTempEnv(env.CopyEnvironment(env.StandardEnvironment))
+		e, err := in.RunFile(s.Name + ".fizz")
+		in.runtime.PopTempEnv()
 		if err != nil {
-			return e, err
+			return diag.Locate(err, s.Span)
 		}
 
 		// Adds the global environment of the imported file to the env of the current one.
 		// It is added as an object instance with the name of the file without the fizz suffix.
-		if err = env.AddImportedFile(name, e); err != nil {
-			return e, err
+		if err = in.runtime.AddImportedFile(name, e); err != nil {
+			return diag.Locate(err, s.Span)
 		}
 	}
 
 	// Include the mentioned libraries in this file. Returns error if names are not
 	// valid library names. The lib package parses the Go functions into Fizz callables.
-	err = lib.IncludeLibraries(includes)
-	if err != nil {
-		return e, err
+	for _, s := range includes {
+		if err = lib.IncludeLibraries(in.runtime, []string{s.Name}); err != nil {
+			return diag.Locate(err, s.Span)
+		}
 	}
 
 	// Set origin point for function declarations. This makes sure that errors give
 	// the correct filename when printed.
-	stmt.CurrentOrigin = filename
+	in.runtime.Origin = filename
 
 	// Finally executes statement tokens. This is the only step that has any effect
 	// on the actual input program as the others were just breaking it up into usable
 	// pieces. While the interpreter is still running, the values of variables will be
-	// remembered as the environments are never reset at runtime.
-	err = stmt.ExecuteStatements(statements)
-	return env.NewEnvironment(), err
+	// remembered as the environments are never reset at runtime. Uncaught control
+	// flow, like a break outside of a loop, is raised as its error.
+	err = stmt.ExecuteStatements(in.runtime, statements)
+	if c, ok := err.(stmt.ConditionalError); ok {
+		return c.Unwrap()
+	}
+
+	return err
 }
 
 // Runs a fizz file. Imports are run as files and the environment is extracted and
 // packaged into a namespace. Said namespace is put into the file it was imported from,
 // which means if "main.fizz" imports "other.fizz", the main file also imports all
 // of the files imported in "other.fizz".
-func RunFile(filename string) (e env.Environment, err error) {
+func (in *Interpreter) RunFile(filename string) (e env.Environment, err error) {
 	if !strings.Contains(filename, ".") {
 		filename = filename + ".fizz"
 	}
 
 	if !strings.HasSuffix(filename, ".fizz") {
-		return e, ErrNonFizzFile
+		return e, ErrNonFizzFile.With()
 	}
 
 	if byt, err := os.ReadFile(filename); err == nil {
-		e, err = Interperate(filename, string(byt))
-		return e, util.WrapFilename(filename, err)
+		return in.Interperate(filename, string(byt))
 	}
 
 	// Unsafe: assumes path error
-	return e, fmt.Errorf(ErrFileNotFound.Error(), filename)
+	return e, ErrFileNotFound.With(filename)
 }
@@ -4,39 +4,62 @@ package lexer
 // for invalid tokens, identifiers, or unlosed strings.
 
 import (
-	"errors"
-	"fmt"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jesperkha/Fizz/diag"
 )
 
 var (
-	ErrUnexpectedToken    = errors.New("unexpeted token: '%s', line: %d")
-	ErrUnterminatedString = errors.New("unterminated string, line %d")
-	ErrInvalidSyntax      = errors.New("invalid syntax '%s', line %d")
+	ErrUnexpectedToken    = diag.New(diag.SyntaxError, "E101", "unexpected token: '%s'")
+	ErrUnterminatedString = diag.New(diag.SyntaxError, "E102", "unterminated string")
+	ErrInvalidSyntax      = diag.New(diag.SyntaxError, "E103", "invalid syntax '%s'")
+	ErrInvalidEscape      = diag.New(diag.SyntaxError, "E104", "invalid escape sequence '%s'")
+	ErrInvalidNumber      = diag.New(diag.SyntaxError, "E105", "invalid number '%s'")
 )
 
+// Template is the literal of a string with embedded expressions. Strings is
+// the text around the expressions, so it has one more element than Exprs.
+type Template struct {
+	Strings []string
+	Exprs   [][]Token
+}
+
+// Token span covers all characters of the lexeme in the source.
 type Token struct {
 	Type    int
 	Lexeme  string
 	Literal interface{}
-	Line    int
+	diag.Span
 }
 
 func GetTokens(input string) (tokens []Token, err error) {
 	currentIdx := 0
 	currentLine := 1 // Start at line 1 for editors
-	alphaNumRegex := regexp.MustCompile("^[a-zA-Z_0-9.]*$")
-	variableRegex := regexp.MustCompile("^[a-zA-Z_][a-zA-Z_0-9]*$")
+	lineStart := 0   // Index of first character in current line
+
+	// Returns span of the characters from start to end index (inclusive).
+	// Columns count characters, not bytes.
+	spanOf := func(start int, end int) diag.Span {
+		col := utf8.RuneCountInString(input[lineStart:start]) + 1
+		endCol := col + utf8.RuneCountInString(input[start:end+1]) - 1
+		return diag.Span{Line: currentLine, Column: col, EndLine: currentLine, EndColumn: endCol}
+	}
+	variableRegex := regexp.MustCompile(`^[\pL_][\pL\pN_]*$`)
 
 	for currentIdx < len(input) {
 		startIndex := currentIdx
 		char := input[currentIdx]
 		nextChar, _ := getNextCharacter(input, currentIdx)
 
+		// A dot before a digit starts a number, like .5, instead of a getter
 		tokenType, isSymbol := tokenLookup[rune(char)]
-		token := Token{Type: tokenType, Lexeme: string(char), Line: currentLine}
+		isSymbol = isSymbol && !(char == '.' && isDigit(byte(nextChar)))
+		token := Token{Type: tokenType, Lexeme: string(char), Span: spanOf(currentIdx, currentIdx)}
 
 		if isSymbol {
 			// Token exception cases
@@ -44,6 +67,7 @@ func GetTokens(input string) (tokens []Token, err error) {
 			case NEWLINE:
 				currentLine++
 				currentIdx++
+				lineStart = currentIdx
 				continue
 			case WHITESPACE:
 				currentIdx++
@@ -53,23 +77,76 @@ func GetTokens(input string) (tokens []Token, err error) {
 				continue
 			}
 
-			// Check for double symbol (!=, >= etc)
-			if nextType, ok := tokenLookup[nextChar]; ok && nextType == EQUAL {
-				jointSymbol := strings.Join([]string{string(char), string(nextChar)}, "")
+			// Check for double symbol (!=, >=, ?? etc)
+			// Safe getters are never followed by a digit, so c ?.5 : 1 is ? and .5
+			jointSymbol := strings.Join([]string{string(char), string(nextChar)}, "")
+			afterNext, _ := getNextCharacter(input, currentIdx+1)
+			isNumber := jointSymbol == "?." && isDigit(byte(afterNext))
+			if doubleType, ok := doubleTokenLookup[jointSymbol]; ok && !isNumber {
 				token.Lexeme = jointSymbol
-				token.Type = doubleTokenLookup[jointSymbol]
+				token.Type = doubleType
+				token.EndColumn++
 				currentIdx++ // Skip next char
 			}
 
-			// Seek closing string
+			// Seek closing string. Raw strings, in backticks, are kept exactly as
+			// written, without escape sequences or embedded expressions.
 			if tokenType == STRING {
-				if seekCharacter(input, &currentIdx, '"') {
-					return tokens, fmt.Errorf(ErrUnterminatedString.Error(), currentLine)
+				raw := char == '`'
+				if raw && seekCharacter(input, &currentIdx, '`') {
+					return tokens, ErrUnterminatedString.At(token.Span)
+				}
+
+				if !raw && seekString(input, &currentIdx) {
+					err := ErrUnterminatedString.At(token.Span)
+					if strings.Contains(input[startIndex:], "{") {
+						err.Hint = "use \\{ for a brace that is not part of an embedded expression"
+					}
+
+					return tokens, err
+				}
+
+				// Returns line and column of index in the string
+				line, start := currentLine, lineStart
+				position := func(idx int) (int, int) {
+					l, s := line, start
+					for i := startIndex; i < idx; i++ {
+						if input[i] == '\n' {
+							l++
+							s = i + 1
+						}
+					}
+
+					return l, utf8.RuneCountInString(input[s:idx]) + 1
+				}
+
+				text, template, isTemplate := input[startIndex+1:currentIdx], Template{}, false
+				if !raw {
+					if template, isTemplate, err = getTemplate(input, startIndex, currentIdx, position); err != nil {
+						return tokens, err
+					}
+
+					if text, err = unescape(input, startIndex+1, currentIdx-1, position); err != nil {
+						return tokens, err
+					}
+				}
+
+				// Strings can span multiple lines
+				for i := startIndex; i < currentIdx; i++ {
+					if input[i] == '\n' {
+						currentLine++
+						lineStart = i + 1
+					}
 				}
 
-				str := intervalToString(input, startIndex, currentIdx)
-				token.Lexeme = str
-				token.Literal = str[1 : len(str)-1]
+				token.Lexeme = input[startIndex : currentIdx+1]
+				token.Literal = text
+				token.EndLine = currentLine
+				token.EndColumn = utf8.RuneCountInString(input[lineStart : currentIdx+1])
+				if isTemplate {
+					token.Type = TEMPLATE
+					token.Literal = template
+				}
 			}
 
 			tokens = append(tokens, token)
@@ -77,38 +154,47 @@ func GetTokens(input string) (tokens []Token, err error) {
 			continue
 		}
 
-		// Not alpha numeric (a-z 0-9 _.)
-		if !alphaNumRegex.MatchString(string(char)) {
-			return tokens, fmt.Errorf(ErrUnexpectedToken.Error(), string(char), currentLine)
+		// Not a letter, digit, underscore, or dot
+		if r, size := utf8.DecodeRuneInString(input[currentIdx:]); !isIdentifierChar(r) {
+			token.Span = spanOf(currentIdx, currentIdx+size-1)
+			return tokens, ErrUnexpectedToken.At(token.Span, string(r))
 		}
 
-		// Char is not a symbol and is the start of an identifier, keyword, or number
-		seekFunc(input, &currentIdx, func(c rune) bool {
-			return !alphaNumRegex.MatchString(string(c))
-		})
+		// Char is not a symbol and is the start of an identifier, keyword, or number.
+		// Identifiers can contain any unicode letter, so the input is read as runes.
+		end := currentIdx
+		for end < len(input) {
+			r, size := utf8.DecodeRuneInString(input[end:])
+			if !isIdentifierChar(r) {
+				break
+			}
+
+			end += size
+		}
+
+		currentIdx = end - 1
 
 		identifier := intervalToString(input, startIndex, currentIdx)
-		number, err := strconv.ParseFloat(identifier, 64)
+		number, isNumber := ParseNumber(identifier)
 		token.Lexeme = identifier
+		token.Span = spanOf(startIndex, currentIdx)
+		if !isNumber && identifier[0] >= '0' && identifier[0] <= '9' {
+			return tokens, ErrInvalidNumber.At(token.Span, identifier)
+		}
 
-		isNumber := err == nil
 		isAlphaNum := variableRegex.MatchString(identifier)
 
 		splitDot := strings.Split(identifier, ".")
 		isGetter := !isNumber && len(splitDot) > 1
 
-		invalidSyntax := fmt.Errorf(ErrInvalidSyntax.Error(), identifier, currentLine)
+		invalidSyntax := ErrInvalidSyntax.At(token.Span, identifier)
 		if !isNumber && !isAlphaNum && !isGetter {
 			return tokens, invalidSyntax
 		}
 
 		if isGetter {
-			dot, err := GetTokens(".")
-			if err != nil {
-				return tokens, err
-			}
-
 			ts := []Token{}
+			offset := startIndex
 			for _, ident := range splitDot {
 				t, err := GetTokens(ident)
 				if err != nil {
@@ -119,13 +205,17 @@ func GetTokens(input string) (tokens []Token, err error) {
 					return tokens, invalidSyntax
 				}
 
-				t[0].Line = token.Line
-				ts = append(ts, dot...)
+				// Place the dot and name at their position in the identifier
+				if offset > startIndex {
+					ts = append(ts, Token{Type: DOT, Lexeme: ".", Span: spanOf(offset-1, offset-1)})
+				}
+
+				t[0].Span = spanOf(offset, offset+len(ident)-1)
 				ts = append(ts, t...)
+				offset += len(ident) + 1
 			}
 
-			// Shift 1 to skip first dot
-			tokens = append(tokens, ts[1:]...)
+			tokens = append(tokens, ts...)
 			currentIdx++
 			continue
 		}
@@ -159,6 +249,58 @@ func GetTokens(input string) (tokens []Token, err error) {
 	return tokens, err
 }
 
+// Parses a number literal. Integers can be written in decimal, or in hex,
+// octal, or binary with the 0x, 0o, and 0b prefixes. They are returned as
+// int, or as *big.Int if they do not fit in an int. Other numbers, like 1.5
+// and 1e3, are float64. Digits can be separated by underscores: 1_000_000.
+func ParseNumber(s string) (number interface{}, ok bool) {
+	if s[0] < '0' || s[0] > '9' {
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+
+	// The prefixed forms are parsed as in Go, which also checks underscores
+	if len(s) > 1 && s[0] == '0' && strings.ContainsRune("xXoObB", rune(s[1])) {
+		if n, err := strconv.ParseInt(s, 0, strconv.IntSize); err == nil {
+			return int(n), true
+		}
+
+		return bigInt(s, 0)
+	}
+
+	for i, c := range s {
+		if c == '_' && (i == 0 || i == len(s)-1 || !isDigit(s[i-1]) || !isDigit(s[i+1])) {
+			return number, false
+		}
+	}
+
+	s = strings.ReplaceAll(s, "_", "")
+	if strings.Trim(s, "0123456789") != "" {
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+
+	if n, err := strconv.ParseInt(s, 10, strconv.IntSize); err == nil {
+		return int(n), true
+	}
+
+	return bigInt(s, 10)
+}
+
+// Parses an integer too large for an int
+func bigInt(s string, base int) (number interface{}, ok bool) {
+	return new(big.Int).SetString(s, base)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Reports whether r can be part of an identifier, keyword, or number
+func isIdentifierChar(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
 // Returns the next character in the input without consuming it
 func getNextCharacter(input string, curIdx int) (nextChar rune, eof bool) {
 	if curIdx < len(input)-1 {
@@ -195,29 +337,204 @@ func seekCharacter(input string, curIdx *int, target rune) (eof bool) {
 	return eof
 }
 
-// Takes an index interval in the input and returns the string
-func intervalToString(input string, startIdx int, endIdx int) string {
-	result := ""
-	for i := startIdx; i <= endIdx; i++ {
-		cur := input[i]
-
-		// Check for special characters
-		if cur == '\\' && len(input) > i+1 {
-			char := ""
-			switch input[i+1] {
-			case 'n':
-				char = "\n"
-			case 't':
-				char = "\t"
-			}
-
-			i++
-			result += char
+// Moves the index to the closing quote of the string starting at the index.
+// Quotes in embedded expressions do not end the string. Returns true if eof.
+func seekString(input string, curIdx *int) (eof bool) {
+	depth := 0
+	for i := *curIdx + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			// Escaped quotes and braces do not end the string or start expressions
+			if depth == 0 {
+				i = skipEscape(input, i)
+			}
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case '"':
+			if depth == 0 {
+				*curIdx = i
+				return false
+			}
+
+			// String inside embedded expression
+			if seekString(input, &i) {
+				return true
+			}
+		}
+	}
+
+	return true
+}
+
+// Returns the index of the last character in the escape sequence starting at
+// i. The braces in \u{...} are part of the escape.
+func skipEscape(input string, i int) int {
+	if strings.HasPrefix(input[i:], "\\u{") {
+		if end := strings.IndexAny(input[i:], "}\""); end != -1 && input[i+end] == '}' {
+			return i + end
+		}
+	}
+
+	return i + 1
+}
+
+// Splits the string from start to end quote into text and embedded expressions.
+// Returns false if there are no expressions. Braces with only whitespace in them
+// are kept as text. Position returns the line and column of an index in the input.
+func getTemplate(input string, start int, end int, position func(int) (int, int)) (template Template, ok bool, err error) {
+	textStart, depth, exprStart := start+1, 0, 0
+	for i := start + 1; i < end; i++ {
+		switch input[i] {
+		case '\\':
+			if depth == 0 {
+				i = skipEscape(input, i)
+			}
+		case '"':
+			if depth > 0 {
+				seekString(input, &i)
+			}
+		case '{':
+			if depth == 0 {
+				exprStart = i + 1
+			}
+
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+
+			if depth--; depth > 0 {
+				continue
+			}
+
+			source := input[exprStart:i]
+			if strings.TrimSpace(source) == "" {
+				continue
+			}
+
+			tokens, err := GetTokens(source)
+			line, col := position(exprStart)
+			if err != nil {
+				if e, ok := err.(*diag.Error); ok {
+					e.Span = shiftSpan(e.Span, line, col)
+				}
+
+				return template, ok, err
+			}
+
+			text, err := unescape(input, textStart, exprStart-2, position)
+			if err != nil {
+				return template, ok, err
+			}
+
+			shiftTokens(tokens, line, col)
+			template.Strings = append(template.Strings, text)
+			template.Exprs = append(template.Exprs, tokens)
+			textStart = i + 1
+		}
+	}
+
+	if len(template.Exprs) == 0 {
+		return template, false, err
+	}
+
+	text, err := unescape(input, textStart, end-1, position)
+	template.Strings = append(template.Strings, text)
+	return template, true, err
+}
+
+// Returns the text from start to end in the input, both included, with escape
+// sequences replaced. Position returns the line and column of an index in the
+// input, and is used to place errors.
+func unescape(input string, start int, end int, position func(int) (int, int)) (text string, err error) {
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		if input[i] != '\\' {
+			b.WriteByte(input[i])
 			continue
 		}
 
-		result += string(cur)
+		// Escape is always followed by a character since the closing quote
+		// cannot be escaped
+		escape, valid := i, true
+		i++
+		switch c := input[i]; c {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		case '"', '\\', '{', '}':
+			b.WriteByte(c)
+		case 'x':
+			// Byte value from two hex digits, \xNN
+			valid = i+2 <= end
+			if valid {
+				n, err := strconv.ParseUint(input[i+1:i+3], 16, 8)
+				valid = err == nil
+				b.WriteByte(byte(n))
+				i += 2
+			}
+		case 'u':
+			// Unicode code point in braces, \u{1F600}
+			close := strings.IndexByte(input[i:end+1], '}')
+			valid = i < end && input[i+1] == '{' && close > 2 && close < 9
+			if valid {
+				n, err := strconv.ParseUint(input[i+2:i+close], 16, 32)
+				valid = err == nil && utf8.ValidRune(rune(n))
+				b.WriteRune(rune(n))
+				i += close
+			}
+		default:
+			valid = false
+		}
+
+		if !valid {
+			line, col := position(escape)
+			span := diag.Span{Line: line, Column: col, EndLine: line, EndColumn: col + 1}
+			return text, ErrInvalidEscape.At(span, input[escape:escape+2])
+		}
+	}
+
+	return b.String(), err
+}
+
+// Moves tokens lexed from a part of the input to the position of the part,
+// which starts at the given line and column.
+func shiftTokens(tokens []Token, line int, col int) {
+	for i := range tokens {
+		tokens[i].Span = shiftSpan(tokens[i].Span, line, col)
+		if t, ok := tokens[i].Literal.(Template); ok {
+			for _, exprTokens := range t.Exprs {
+				shiftTokens(exprTokens, line, col)
+			}
+		}
+	}
+}
+
+func shiftSpan(span diag.Span, line int, col int) diag.Span {
+	if span.Line == 1 {
+		span.Column += col - 1
+	}
+
+	if span.EndLine == 1 {
+		span.EndColumn += col - 1
 	}
 
-	return result
+	span.Line += line - 1
+	span.EndLine += line - 1
+	return span
+}
+
+// Takes an index interval in the input and returns the string
+func intervalToString(input string, startIdx int, endIdx int) string {
+	return input[startIdx : endIdx+1]
 }
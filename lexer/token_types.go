@@ -5,27 +5,38 @@ const (
 	NOT_TOKEN = iota
 
 	// Expression types
+	NIL_COALESCE
 	AND
 	OR
 	EQUAL_EQUAL
 	NOT_EQUAL
 	IN
+	IS
 	GREATER
 	LESS
 	GREATER_EQUAL
 	LESS_EQUAL
 
+	BIT_OR
+	BIT_XOR
+	BIT_AND
+	SHIFT_LEFT
+	SHIFT_RIGHT
+
 	PLUS
 	MINUS
 	STAR
 	SLASH
+	FLOOR_DIV
 	MODULO
 	HAT
 
 	TYPE
 	NOT
+	BIT_NOT
 
 	STRING
+	TEMPLATE
 	NUMBER
 	TRUE
 	FALSE
@@ -41,6 +52,8 @@ const (
 	RIGHT_SQUARE
 	COMMA
 	DOT
+	SAFE_DOT
+	QUESTION
 	SEMICOLON
 	COMMENT
 	EQUAL
@@ -68,12 +81,31 @@ const (
 	INCLUDE
 	REPEAT
 	RETURN
+	TRY
+	CATCH
 
 	WHITESPACE
 	NEWLINE
 	EOF
 )
 
+// Returns the precedence of the token type. Operators with the same precedence
+// are evaluated left to right. Other token types have their own precedence.
+func Precedence(tokenType int) int {
+	switch tokenType {
+	case LESS, GREATER_EQUAL, LESS_EQUAL:
+		return GREATER
+	case MINUS:
+		return PLUS
+	case SLASH, FLOOR_DIV:
+		return STAR
+	case SHIFT_RIGHT:
+		return SHIFT_LEFT
+	}
+
+	return tokenType
+}
+
 var tokenLookup = map[rune]int{
 	'\t': WHITESPACE,
 	'\r': WHITESPACE,
@@ -97,8 +129,10 @@ var tokenLookup = map[rune]int{
 	';': SEMICOLON,
 	',': COMMA,
 	'.': DOT,
+	'?': QUESTION,
 	'#': COMMENT,
 	'"': STRING,
+	'`': STRING,
 
 	'=': EQUAL,
 	'!': NOT,
@@ -118,6 +152,11 @@ var doubleTokenLookup = map[string]int{
 	"*=": MULT_EQUAL,
 	"/=": DIV_EQUAL,
 	":=": DEF_EQUAL,
+	"??": NIL_COALESCE,
+	"?.": SAFE_DOT,
+	"<<": SHIFT_LEFT,
+	">>": SHIFT_RIGHT,
+	"//": FLOOR_DIV,
 }
 
 var keyWordLookup = map[string]int{
@@ -141,6 +180,13 @@ var keyWordLookup = map[string]int{
 	"include": INCLUDE,
 	"error":   ERROR,
 	"in":      IN,
+	"is":      IS,
 	"enum":    ENUM,
 	"range":   RANGE,
+	"try":     TRY,
+	"catch":   CATCH,
+	"bitand":  BIT_AND,
+	"bitor":   BIT_OR,
+	"bitxor":  BIT_XOR,
+	"bitnot":  BIT_NOT,
 }
@@ -2,12 +2,12 @@ package io
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"os"
 
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/env"
 )
 
@@ -17,7 +17,7 @@ type i interface{}
 
 var (
 	scanner        = bufio.NewScanner(os.Stdin)
-	ErrInvalidPath = errors.New("invalid path, line %d")
+	ErrInvalidPath = diag.New(diag.RuntimeError, "E411", "invalid path")
 )
 
 /*
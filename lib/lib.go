@@ -1,10 +1,10 @@
 package lib
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/env"
 	"github.com/jesperkha/Fizz/util"
 )
@@ -14,10 +14,13 @@ type FuncMap map[string]interface{}
 
 var (
 	LibList        = map[string]FuncMap{}
-	ErrNotLib      = errors.New("'%s' is not a library")
-	ErrNotFunction = errors.New("in lib %s, '%s' is not a function")
-	ErrNumReturn   = errors.New("in lib %s, '%s()' return incorrect number of values")
-	ErrReturnTypes = errors.New("in lib %s, '%s()' does not return (interface{}, error)")
+	ErrNotLib      = diag.New(diag.ImportError, "E311", "'%s' is not a library")
+	ErrNotFunction = diag.New(diag.ImportError, "E312", "in lib %s, '%s' is not a function")
+	ErrNumReturn   = diag.New(diag.ImportError, "E313", "in lib %s, '%s()' return incorrect number of values")
+	ErrReturnTypes = diag.New(diag.ImportError, "E314", "in lib %s, '%s()' does not return (interface{}, error)")
+	ErrArgCount    = diag.New(diag.TypeError, "E401", "%s() expected %d args, got %d")
+	ErrArgType     = diag.New(diag.TypeError, "E402", "%s() expected arg %d to be %s, got %s")
+	ErrFailedCall  = diag.New(diag.RuntimeError, "E403", "%s(): %s")
 )
 
 // Adds inclusion map to global include list if lib name is required
@@ -32,34 +35,34 @@ func Add(libName string, functions FuncMap) {
 // Checks if function is valid. Returns error if not.
 func VerifyFunction(lib string, fname string, f i) error {
 	if reflect.TypeOf(f).Kind() != reflect.Func {
-		return fmt.Errorf(ErrNotFunction.Error(), lib, fname)
+		return ErrNotFunction.With(lib, fname)
 	}
 
 	typ := reflect.TypeOf(f)
 	if typ.NumOut() != 2 {
-		return fmt.Errorf(ErrNumReturn.Error(), lib, fname)
+		return ErrNumReturn.With(lib, fname)
 	}
 
 	if typ.Out(0).Kind() != reflect.Interface || typ.Out(1).Kind() != reflect.Interface {
-		return fmt.Errorf(ErrReturnTypes.Error(), lib, fname)
+		return ErrReturnTypes.With(lib, fname)
 	}
 
 	return nil
 }
 
-// Imports all included libs to running fizz process
-func IncludeLibraries(includes []string) error {
+// Imports all included libs to the given runtime
+func IncludeLibraries(rt *env.Runtime, includes []string) error {
 	for _, name := range includes {
 		// Check if name is in list of valid libs
 		library, ok := LibList[name]
 		if !ok {
-			return fmt.Errorf(ErrNotLib.Error(), name)
+			return ErrNotLib.With(name)
 		}
 
 		// Declare all functions in library. Push new scope, declare functions
 		// and then get env before popping scope again. This simulates a file
 		// import and the functions are added the same way as normal imports.
-		env.PushScope()
+		rt.PushScope()
 		for funcName, f := range library {
 			// Verify functions at runtime to not lag on startup
 			err := VerifyFunction(name, funcName, f)
@@ -83,15 +86,15 @@ func IncludeLibraries(includes []string) error {
 			}
 
 			// Declare to scope it was required in
-			if err := env.Declare(funcName, &callable); err != nil {
+			if err := rt.Declare(funcName, &callable); err != nil {
 				return err
 			}
 		}
 
 		// Get env and pop. Add as import
-		defEnv := env.GetCurrentEnv()
-		env.PopScope()
-		if err := env.AddImportedFile(name, defEnv); err != nil {
+		defEnv := rt.GetCurrentEnv()
+		rt.PopScope()
+		if err := rt.AddImportedFile(name, defEnv); err != nil {
 			return err
 		}
 	}
@@ -111,8 +114,7 @@ func CallFunc(name string, function i, args []interface{}) (val i, err error) {
 	numArgs := f.Type().NumIn()
 	gotArgs := len(args)
 	if numArgs != gotArgs {
-		s := fmt.Sprintf("%s() expected %d args, got %d", name, numArgs, gotArgs)
-		return val, errors.New(s + ", line %d")
+		return val, ErrArgCount.With(name, numArgs, gotArgs)
 	}
 
 	// Convert args to reflect.Value
@@ -120,6 +122,12 @@ func CallFunc(name string, function i, args []interface{}) (val i, err error) {
 	for idx, value := range args {
 		// Arg value types must match for lib functions
 		paramType := f.Type().In(idx)
+
+		// Integers are passed as floats to functions taking float64
+		if n, ok := env.ToFloat(value); ok && paramType.Kind() == reflect.Float64 {
+			value = n
+		}
+
 		argType := reflect.TypeOf(value)
 		// Interface param type doesnt need type check.
 		// Unsafe: i might not be defined as interface
@@ -127,11 +135,7 @@ func CallFunc(name string, function i, args []interface{}) (val i, err error) {
 			// Get fizz names for types
 			expect := util.GetLibType(paramType.Name())
 			got := util.GetType(value)
-
-			// Do crazy error shenanigans to avoid writing twice
-			s := "%s() expected arg %d to be %s, got %s"
-			e := fmt.Sprintf(s, name, idx+1, expect, got)
-			return val, errors.New(e + ", line %d")
+			return val, ErrArgType.With(name, idx+1, expect, got)
 		}
 
 		argsIn[idx] = reflect.ValueOf(value)
@@ -148,6 +152,11 @@ func CallFunc(name string, function i, args []interface{}) (val i, err error) {
 		err = res[1].Interface().(error)
 	}
 
+	// Errors not declared by the library are wrapped so they can be located
+	if _, ok := err.(*diag.Error); err != nil && !ok {
+		err = ErrFailedCall.With(name, err)
+	}
+
 	// Returned value must be error based in map type
 	return value, err
 }
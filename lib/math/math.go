@@ -2,8 +2,11 @@ package math
 
 import (
 	"math"
+	"math/big"
 	"math/rand"
 	"time"
+
+	"github.com/jesperkha/Fizz/env"
 )
 
 // Standard math package for most common mathematical operations
@@ -44,14 +47,14 @@ func Atan(num float64) (val i, err error) {
 	return math.Atan(num), err
 }
 
-/* func floor(num float64) float64 */
+/* func floor(num float64) number */
 func Floor(num float64) (val i, err error) {
-	return math.Floor(num), err
+	return toInteger(math.Floor(num)), err
 }
 
-/* func ceil(num float64) float64 */
+/* func ceil(num float64) number */
 func Ceil(num float64) (val i, err error) {
-	return math.Ceil(num), err
+	return toInteger(math.Ceil(num)), err
 }
 
 /* func abs(num float64) float64 */
@@ -107,3 +110,14 @@ func Deg(num float64) (val i, err error) {
 func Random() (val i, err error) {
 	return rand.Float64(), err
 }
+
+// Returns the whole number as an integer. NaN and infinity are not integers
+// and are returned as they are.
+func toInteger(num float64) interface{} {
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		return num
+	}
+
+	n, _ := new(big.Float).SetFloat64(num).Int(nil)
+	return env.FromBig(n)
+}
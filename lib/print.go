@@ -2,12 +2,13 @@ package lib
 
 import (
 	"embed"
-	"errors"
 	"fmt"
+
+	"github.com/jesperkha/Fizz/diag"
 )
 
 var (
-	ErrNotALibrary = errors.New("'%s' is not a known library")
+	ErrNotALibrary = diag.New(diag.ImportError, "E315", "'%s' is not a known library")
 
 	//go:embed _libdump
 	embeddedDocs embed.FS
@@ -19,7 +20,7 @@ func PrintDocs(libname string) error {
 	filename := fmt.Sprintf("_libdump/%s.txt", libname)
 	file, err := embeddedDocs.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf(ErrNotALibrary.Error(), libname)
+		return ErrNotALibrary.With(libname)
 	}
 
 	fmt.Println()
@@ -1,12 +1,12 @@
 package str
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/env"
+	"github.com/jesperkha/Fizz/lexer"
 	"github.com/jesperkha/Fizz/util"
 )
 
@@ -15,8 +15,8 @@ import (
 type i interface{}
 
 var (
-	ErrNotNumber = errors.New("string could not be converted to number, line %d")
-	ErrNotString = errors.New("expected string value in array, line %d")
+	ErrNotNumber = diag.New(diag.RuntimeError, "E421", "string could not be converted to number")
+	ErrNotString = diag.New(diag.TypeError, "E422", "expected string value in array")
 )
 
 /*
@@ -99,14 +99,33 @@ func Replace(str string, old string, new string) (val i, err error) {
 }
 
 /*
-	Converts string to number.
-	func toNumber(str string) float64
+	Converts string to number. Uses the same rules as number literals, so
+	"42" and "0xFF" give integers and "1.5" gives a float. May start with a sign.
+	func toNumber(str string) number
 */
 func ToNumber(val string) (num i, err error) {
-	num, err = strconv.ParseFloat(val, 64)
-	if err != nil {
-		return num, ErrNotNumber
+	sign := ""
+	if strings.HasPrefix(val, "-") || strings.HasPrefix(val, "+") {
+		sign, val = val[:1], val[1:]
 	}
 
-	return num, err
+	ok := false
+	if val != "" {
+		num, ok = lexer.ParseNumber(val)
+	}
+
+	if !ok {
+		return nil, ErrNotNumber
+	}
+
+	if sign != "-" {
+		return num, err
+	}
+
+	if f, ok := num.(float64); ok {
+		return -f, err
+	}
+
+	b, _ := env.ToBig(num)
+	return env.FromBig(b.Neg(b)), err
 }
@@ -5,9 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
-	"github.com/jesperkha/Fizz/env"
 	"github.com/jesperkha/Fizz/interp"
 	"github.com/jesperkha/Fizz/lib"
 	"github.com/jesperkha/Fizz/stmt"
@@ -16,8 +16,10 @@ import (
 )
 
 var (
-	ErrOneArgOnly = errors.New("expected a single argument, got %d")
-	validArgs     = []string{"--help", "--version", "-f", "-e"}
+	ErrOneArgOnly    = errors.New("expected a single argument, got %d")
+	ErrUnknownFormat = errors.New("unknown error format '%s', expected 'text' or 'json'")
+	ErrInvalidDepth  = errors.New("max depth must be a positive integer, got '%s'")
+	validArgs        = []string{"--help", "--version", "--error-format", "--max-depth", "-f", "-e"}
 )
 
 func RunInterpreter() {
@@ -30,6 +32,20 @@ func RunInterpreter() {
 		util.ErrorAndExit(fmt.Errorf(ErrOneArgOnly.Error(), len(args)))
 	}
 
+	format := parser.Option("error-format")
+	if format != "" && format != "text" && format != "json" {
+		util.ErrorAndExit(fmt.Errorf(ErrUnknownFormat.Error(), format))
+	}
+
+	maxDepth := 0
+	if depth := parser.Option("max-depth"); parser.HasOption("max-depth") {
+		if n, err := strconv.Atoi(depth); err == nil && n > 0 {
+			maxDepth = n
+		} else {
+			util.ErrorAndExit(fmt.Errorf(ErrInvalidDepth.Error(), depth))
+		}
+	}
+
 	// Early exit options
 	if parser.HasOption("help") {
 		fmt.Println(term.HELP)
@@ -68,18 +84,28 @@ func RunInterpreter() {
 	os.Chdir(path)
 
 	// Run file
-	e, err := interp.RunFile(name)
+	in := interp.New()
+	if maxDepth != 0 {
+		in.SetMaxDepth(maxDepth)
+	}
+
+	e, err := in.RunFile(name)
 
 	// Print global environment if flag is set first
 	if parser.HasFlag("e") {
 		fmt.Println(util.FormatPrintValue(e))
 	}
 
-	// Handle error
+	// Handle error. The JSON format always includes the callstack
 	if err != nil && err != stmt.ErrProgramExit {
-		util.PrintError(err)
-		if c := env.GetCallstack(); parser.HasFlag("f") && len(c) > 0 {
-			util.PrintError(fmt.Errorf(c))
+		if format == "json" {
+			util.PrintJSONError(err, in.Callstack())
+			os.Exit(1)
+		}
+
+		util.PrintDiagnostic(err, in.Source)
+		if c := in.GetCallstack(); parser.HasFlag("f") && len(c) > 0 {
+			util.PrintError(errors.New(c))
 		}
 
 		os.Exit(1)
@@ -93,7 +119,18 @@ func RunTerminal() {
 	scanner := bufio.NewScanner(os.Stdin)
 	numBlocks, line := 0, 1
 	totalString, space := "", " "
-	env.ThrowEnvironment = false
+	waitFor := "" // Keyword that can continue the last statement
+	in := interp.New()
+	in.SetThrowEnvironment(false)
+
+	run := func() {
+		if _, err := in.Interperate("", totalString); err != nil {
+			util.PrintDiagnostic(err, in.Source)
+			line--
+		}
+
+		totalString = ""
+	}
 
 	for {
 		fmt.Printf("%d%s : %s", line, space, strings.Repeat("    ", numBlocks))
@@ -101,19 +138,34 @@ func RunTerminal() {
 		input := scanner.Text()
 
 		if input == "exit" {
+			if waitFor != "" {
+				run()
+			}
+
 			break
 		}
 
+		// If and try statements are run when the next line does not continue
+		// them with else or catch
+		trimmed := strings.TrimSpace(input)
+		if waitFor != "" && !strings.HasPrefix(trimmed, waitFor) {
+			run()
+		}
+
 		// Continue with indent after braces
 		numBlocks += strings.Count(input, "{") - strings.Count(input, "}")
 		totalString += input + "\n" // Better error handling
+		waitFor = ""
 		if numBlocks <= 0 {
-			if _, err := interp.Interperate("", totalString); err != nil {
-				util.PrintError(err)
-				line--
+			statement := strings.TrimSpace(totalString)
+			if strings.HasPrefix(statement, "if") && strings.HasSuffix(trimmed, "}") {
+				waitFor = "else"
+			} else if strings.HasPrefix(statement, "try") && !strings.Contains(statement, "catch") {
+				waitFor = "catch"
+			} else {
+				run()
 			}
 
-			totalString = ""
 			numBlocks = 0
 		}
 
@@ -1,74 +1,74 @@
 package stmt
 
 import (
-	"errors"
 	"fmt"
 
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/env"
 	"github.com/jesperkha/Fizz/expr"
 	"github.com/jesperkha/Fizz/lexer"
 	"github.com/jesperkha/Fizz/util"
 )
 
-var (
-	CurrentOrigin     string
-	MaxRecursionDepth = 1000
-)
-
 // Goes through list of statements and executes them. Error is returned from statements exec method.
-func ExecuteStatements(stmts []Statement) (err error) {
+func ExecuteStatements(rt *env.Runtime, stmts []Statement) (err error) {
 	for _, statement := range stmts {
-		line := statement.Line
-		if err = executeStatement(statement); err != nil {
+		if err = executeStatement(rt, statement); err != nil {
+			// Only set span at the statement the error was raised in
 			if cerr, ok := err.(ConditionalError); ok {
-				cerr.Msg = fmt.Sprintf(cerr.Msg, line)
+				if cerr.Line == 0 {
+					cerr.Span = statement.Span
+				}
+
 				return cerr
 			}
 
-			return util.FormatError(err, line)
+			return diag.Locate(err, statement.Span)
 		}
 	}
 
 	return err
 }
 
-func executeStatement(stmt Statement) error {
+func executeStatement(rt *env.Runtime, stmt Statement) error {
 	switch stmt.Type {
 	case ExpressionStmt:
-		_, err := expr.EvaluateExpression(stmt.Expression)
+		_, err := expr.EvaluateExpression(rt, stmt.Expression)
 		return err
 	case Block:
-		return execBlock(stmt)
+		return execBlock(rt, stmt)
 	case Print:
-		return execPrint(stmt)
+		return execPrint(rt, stmt)
 	case Variable:
 		return nil
 	case Assignment:
-		return execAssignment(stmt)
+		return execAssignment(rt, stmt)
 	case Break:
 		return ErrBeakOutsideLoop
 	case Skip:
 		return ErrSkipOutsideLoop
 	case Return:
-		return execReturn(stmt)
+		return execReturn(rt, stmt)
 	case If:
-		return execIf(stmt)
+		return execIf(rt, stmt)
 	case While:
-		return execWhile(stmt)
+		return execWhile(rt, stmt)
 	case Repeat:
-		return execRepeat(stmt)
+		return execRepeat(rt, stmt)
 	case Function:
-		return execFunction(stmt)
+		return execFunction(rt, stmt)
 	case Exit:
-		return execExit(stmt)
+		return execExit(rt, stmt)
 	case Error:
-		return execError(stmt)
+		return execError(rt, stmt)
 	case Object:
-		return execObject(stmt)
+		return execObject(rt, stmt)
 	case Enum:
-		return execEnum(stmt)
+		return execEnum(rt, stmt)
 	case Range:
-		return execRange(stmt)
+		return execRange(rt, stmt)
+	case Try:
+		return execTry(rt, stmt)
 	case Import, Include:
 		return nil // Handled in interp
 	}
@@ -79,9 +79,81 @@ func executeStatement(stmt Statement) error {
 	return ErrInvalidStmtType
 }
 
-func execEnum(stmt Statement) (err error) {
+// Assigns each value to the target at the same position. All values are
+// evaluated before any are assigned, so a, b = b, a swaps the values.
+func execMultipleAssignment(rt *env.Runtime, stmt Statement) (err error) {
+	targets := stmt.Left.Exprs
+	values, err := unpackValues(rt, stmt.Expression, targets)
+	if err != nil {
+		return err
+	}
+
+	for i, target := range targets {
+		if stmt.Operator == lexer.DEF_EQUAL {
+			err = rt.Declare(target.Name, values[i])
+		} else {
+			err = assignValue(rt, &target, values[i])
+		}
+
+		if err != nil {
+			return diag.Locate(err, target.Span)
+		}
+	}
+
+	return err
+}
+
+// Returns the values to assign to the targets. The values are either a comma
+// separated list, or a single array or object to unpack. Objects are unpacked
+// by field name, so the targets must be variables with the same names as the
+// fields.
+func unpackValues(rt *env.Runtime, right *expr.Expression, targets []expr.Expression) (values []interface{}, err error) {
+	if right.Type == expr.Args {
+		for _, e := range right.Exprs {
+			value, err := expr.EvaluateExpression(rt, &e)
+			if err != nil {
+				return values, err
+			}
+
+			values = append(values, value)
+		}
+	} else {
+		value, err := expr.EvaluateExpression(rt, right)
+		if err != nil {
+			return values, err
+		}
+
+		switch v := value.(type) {
+		case *env.Array:
+			values = append(values, v.Values...)
+		case *env.Object:
+			for _, target := range targets {
+				if target.Type != expr.Variable {
+					return values, ErrExpectedIdentifier.At(target.Span)
+				}
+
+				field, err := v.Get(target.Name)
+				if err != nil {
+					return values, diag.Locate(err, target.Span)
+				}
+
+				values = append(values, field)
+			}
+		default:
+			return values, ErrCannotUnpack.At(right.Span, util.GetType(value))
+		}
+	}
+
+	if len(values) != len(targets) {
+		return values, ErrUnpackCount.At(right.Span, len(targets), len(values))
+	}
+
+	return values, err
+}
+
+func execEnum(rt *env.Runtime, stmt Statement) (err error) {
 	for curVal, name := range stmt.Params {
-		err = env.Declare(name, float64(curVal))
+		err = rt.Declare(name, curVal)
 		if err != nil {
 			return err
 		}
@@ -90,9 +162,9 @@ func execEnum(stmt Statement) (err error) {
 	return err
 }
 
-func execExit(stmt Statement) (err error) {
+func execExit(rt *env.Runtime, stmt Statement) (err error) {
 	if stmt.Expression != nil {
-		if err = execPrint(stmt); err != nil {
+		if err = execPrint(rt, stmt); err != nil {
 			return err
 		}
 	}
@@ -100,24 +172,47 @@ func execExit(stmt Statement) (err error) {
 	return ErrProgramExit
 }
 
-func execError(stmt Statement) (err error) {
-	value, err := expr.EvaluateExpression(stmt.Expression)
+func execError(rt *env.Runtime, stmt Statement) (err error) {
+	value, err := expr.EvaluateExpression(rt, stmt.Expression)
 	if err != nil {
 		return err
 	}
 
-	return errors.New(util.FormatPrintValue(value))
+	// The value is kept so it can be caught, or read by the embedding program
+	e := ErrRaised.With(errorMessage(value))
+	e.Value = value
+	return e
+}
+
+// Objects with a message field and maps with a message key use it as the
+// error message
+func errorMessage(value interface{}) string {
+	if obj, ok := value.(*env.Object); ok {
+		if msg, ok := obj.Fields["message"].(string); ok {
+			return msg
+		}
+	}
+
+	if m, ok := value.(*env.Map); ok {
+		if msg, err := m.Get("message"); err == nil {
+			if msg, ok := msg.(string); ok {
+				return msg
+			}
+		}
+	}
+
+	return util.FormatPrintValue(value)
 }
 
 // Raises error and assigns expr value to global currentReturnValue
-func execReturn(stmt Statement) (err error) {
+func execReturn(rt *env.Runtime, stmt Statement) (err error) {
 	e := ErrReturnOutsideFunc
 	if stmt.Expression == nil {
 		e.Value = nil
 		return e
 	}
 
-	value, err := expr.EvaluateExpression(stmt.Expression)
+	value, err := expr.EvaluateExpression(rt, stmt.Expression)
 	if err != nil {
 		return err
 	}
@@ -126,8 +221,8 @@ func execReturn(stmt Statement) (err error) {
 	return e
 }
 
-func execPrint(stmt Statement) (err error) {
-	value, err := expr.EvaluateExpression(stmt.Expression)
+func execPrint(rt *env.Runtime, stmt Statement) (err error) {
+	value, err := expr.EvaluateExpression(rt, stmt.Expression)
 	if err != nil {
 		return err
 	}
@@ -136,23 +231,38 @@ func execPrint(stmt Statement) (err error) {
 	return nil
 }
 
-func assignValue(left *expr.Expression, value interface{}) error {
+func assignValue(rt *env.Runtime, left *expr.Expression, value interface{}) error {
 	if left.Type == expr.Variable {
-		return env.Assign(left.Name, value)
+		return rt.Assign(left.Name, value)
 	}
 
-	// First evaluate the entire expression to pluck out any
-	// errors that are harder to check for later
-	if _, err := expr.EvaluateExpression(left); err != nil {
-		return err
+	if left.Type != expr.Index && left.Type != expr.Getter {
+		return ErrNonAssignable
 	}
 
 	// Get left expression of left expression (parent)
-	val, err := expr.EvaluateExpression(left.Left)
+	val, err := expr.EvaluateExpression(rt, left.Left)
 	if err != nil {
 		return err
 	}
 
+	// Assigning to a map key adds it if it is not already in the map,
+	// so the entire expression is not evaluated first
+	if m, ok := val.(*env.Map); ok && left.Type == expr.Index {
+		key, err := expr.EvaluateExpression(rt, left.Right)
+		if err != nil {
+			return err
+		}
+
+		return m.Set(key, value)
+	}
+
+	// Evaluate the entire expression to pluck out any
+	// errors that are harder to check for later
+	if _, err := expr.EvaluateExpression(rt, left); err != nil {
+		return err
+	}
+
 	// If object assign to name of parent expression
 	if obj, ok := val.(*env.Object); ok {
 		return obj.Set(left.Right.Name, value)
@@ -160,7 +270,7 @@ func assignValue(left *expr.Expression, value interface{}) error {
 
 	// If array assign value to index of parent expression
 	if arr, ok := val.(*env.Array); ok {
-		index, err := expr.EvaluateExpression(left.Right)
+		index, err := expr.EvaluateExpression(rt, left.Right)
 		if err != nil {
 			return err
 		}
@@ -176,15 +286,19 @@ func assignValue(left *expr.Expression, value interface{}) error {
 	return ErrNonAssignable
 }
 
-func execAssignment(stmt Statement) (err error) {
-	val, err := expr.EvaluateExpression(stmt.Expression)
+func execAssignment(rt *env.Runtime, stmt Statement) (err error) {
+	if stmt.Left.Type == expr.Args {
+		return execMultipleAssignment(rt, stmt)
+	}
+
+	val, err := expr.EvaluateExpression(rt, stmt.Expression)
 	if err != nil {
 		return err
 	}
 
 	// Plain assignment
 	if stmt.Operator == lexer.EQUAL {
-		return assignValue(stmt.Left, val)
+		return assignValue(rt, stmt.Left, val)
 	}
 
 	// Declare variable with special := operator
@@ -193,10 +307,10 @@ func execAssignment(stmt Statement) (err error) {
 			return ErrInvalidStatement
 		}
 
-		return env.Declare(stmt.Left.Name, val)
+		return rt.Declare(stmt.Left.Name, val)
 	}
 
-	oldVal, err := expr.EvaluateExpression(stmt.Left)
+	oldVal, err := expr.EvaluateExpression(rt, stmt.Left)
 	if err != nil {
 		return err
 	}
@@ -213,118 +327,105 @@ func execAssignment(stmt Statement) (err error) {
 			return ErrInvalidOperator
 		}
 
-		return assignValue(stmt.Left, oldVal.(string)+val.(string))
+		return assignValue(rt, stmt.Left, oldVal.(string)+val.(string))
 	}
 
-	// Float addition / subtraction
+	// Number operators follow the same rules as the binary expressions
 	if newType == "number" {
-		a := oldVal.(float64)
-		b := val.(float64)
-		var newVal float64
-
-		switch stmt.Operator {
-		case lexer.PLUS_EQUAL:
-			newVal = a + b
-		case lexer.MINUS_EQUAL:
-			newVal = a - b
-		case lexer.MULT_EQUAL:
-			newVal = a * b
-		case lexer.DIV_EQUAL:
-			newVal = a / b
+		op := map[int]int{
+			lexer.PLUS_EQUAL:  lexer.PLUS,
+			lexer.MINUS_EQUAL: lexer.MINUS,
+			lexer.MULT_EQUAL:  lexer.STAR,
+			lexer.DIV_EQUAL:   lexer.SLASH,
+		}[stmt.Operator]
+
+		newVal, err := expr.Arithmetic(op, oldVal, val)
+		if err != nil {
+			return err
 		}
 
-		return assignValue(stmt.Left, newVal)
+		return assignValue(rt, stmt.Left, newVal)
 	}
 
 	return ErrInvalidStatement
 }
 
-func execBlock(stmt Statement) (err error) {
-	env.PushScope()
-	err = ExecuteStatements(stmt.Statements)
-	env.PopScope()
+func execBlock(rt *env.Runtime, stmt Statement) (err error) {
+	rt.PushScope()
+	err = ExecuteStatements(rt, stmt.Statements)
+	rt.PopScope()
 	return err
 }
 
-// Monitor recursion
-var lastFunction = ""
-var recursionDepth = 0
+func execFunction(rt *env.Runtime, stmt Statement) (err error) {
+	return rt.Declare(stmt.Name, newFunction(rt, stmt))
+}
+
+// Creates function value from a function literal. Set as the expr.EvalLambda hook.
+func evalLambda(rt *env.Runtime, lambda interface{}) (value interface{}, err error) {
+	return newFunction(rt, lambda.(Statement)), err
+}
 
-func execFunction(stmt Statement) (err error) {
+// Creates callable for function statement. The function closes over the current
+// scope, so changes made to it later, like declaring the function itself, are
+// available inside the function body.
+func newFunction(rt *env.Runtime, stmt Statement) *env.Callable {
 	// Store origin at point of function declaration as well as scope around it
-	originCache := CurrentOrigin
-	var envCache env.Environment
+	originCache := rt.Origin
+	envCache := rt.GetCurrentEnv()
 
-	function := env.Callable{
+	return &env.Callable{
 		Name:    stmt.Name,
 		NumArgs: len(stmt.Params),
-		Origin:  CurrentOrigin,
+		Origin:  originCache,
 		// Call function and set param variables to scope
 		Call: func(args ...interface{}) (interface{}, error) {
-			// Handle recursion errors
-			name := stmt.Name
-			if lastFunction == name {
-				recursionDepth++
-			} else {
-				lastFunction = name
-			}
-
-			// Todo: better recursive checks for recursive limit
-			if recursionDepth > MaxRecursionDepth {
-				return nil, util.WrapFilename(originCache, ErrMaximumRecursion)
-			}
+			// Calls made in the function body are made from the file it was declared in
+			callerOrigin := rt.Origin
+			rt.Origin = originCache
 
 			// Push closure scope into stack
-			env.PushTempEnv(envCache)
-			env.PushScope()
+			rt.PushTempEnv(envCache)
+			rt.PushScope()
 
 			// Declare args
 			for idx, arg := range args {
 				// Cannot raise error because block is in own scope
-				env.Declare(stmt.Params[idx], arg)
+				rt.Declare(stmt.Params[idx], arg)
 			}
 
-			err = ExecuteStatements(stmt.Then.Statements)
-			env.PopScope()
-			env.PopTempEnv()
+			err := ExecuteStatements(rt, stmt.Then.Statements)
+			rt.PopScope()
+			rt.PopTempEnv()
+			rt.Origin = callerOrigin
 			if e, ok := err.(ConditionalError); ok {
 				return e.Value, nil
 			}
 
-			// Add to callstack
-			if err != nil {
-				env.FailCall(stmt.Name, originCache, stmt.Line)
-			}
-
 			return nil, util.WrapFilename(originCache, err)
 		},
 	}
-
-	err = env.Declare(stmt.Name, &function)
-	// Set after function is declared to allow using the function inside its body
-	envCache = env.GetCurrentEnv()
-	return err
 }
 
-func execIf(stmt Statement) (err error) {
-	val, err := expr.EvaluateExpression(stmt.Expression)
+func execIf(rt *env.Runtime, stmt Statement) (err error) {
+	val, err := expr.EvaluateExpression(rt, stmt.Expression)
 	if err != nil {
 		return err
 	}
 
 	if val != nil && val != false {
-		return ExecuteStatements(stmt.Then.Statements)
+		return ExecuteStatements(rt, stmt.Then.Statements)
 	} else if stmt.Else != nil {
-		return ExecuteStatements(stmt.Else.Statements)
+		return ExecuteStatements(rt, stmt.Else.Statements)
 	}
 
 	return err
 }
 
-func loopStatements(stmts []Statement) (brk bool, err error) {
-	env.PushScope()
-	err = ExecuteStatements(stmts)
-	env.PopScope()
+func loopStatements(rt *env.Runtime, stmts []Statement) (brk bool, err error) {
+	rt.PushScope()
+	err = ExecuteStatements(rt, stmts)
+	rt.PopScope()
 	if e, ok := err.(ConditionalError); ok {
 		switch e.Type {
 		case BREAK:
@@ -337,11 +438,57 @@ func loopStatements(stmts []Statement) (brk bool, err error) {
 	return false, err
 }
 
+// Runs the catch block if the try block raises an error, except for control flow and exit
+func execTry(rt *env.Runtime, stmt Statement) (err error) {
+	err = execBlock(rt, *stmt.Then)
+	if _, ok := err.(ConditionalError); ok || err == nil || err == ErrProgramExit {
+		return err
+	}
+
+	// The error is handled so its trace is no longer needed
+	rt.ResetCallstack()
+	rt.PushScope()
+	if stmt.Name != "" {
+		rt.Declare(stmt.Name, errorValue(rt, err))
+	}
+
+	err = ExecuteStatements(rt, stmt.Else.Statements)
+	rt.PopScope()
+	return err
+}
+
+// Creates the value caught by a catch block. The value given to the error
+// statement is kept as is. Errors not made by the interpreter only have a
+// message.
+func errorValue(rt *env.Runtime, err error) *env.Object {
+	fields := map[string]interface{}{
+		"message": err.Error(),
+		"value":   nil,
+		"code":    nil,
+		"file":    rt.Origin,
+		"line":    nil,
+		"column":  nil,
+	}
+
+	if d, ok := err.(*diag.Error); ok {
+		fields["message"] = d.Msg
+		fields["value"] = d.Value
+		fields["code"] = d.Code
+		fields["line"] = d.Span.Line
+		fields["column"] = d.Span.Column
+		if d.File != "" {
+			fields["file"] = d.File
+		}
+	}
+
+	return env.NewObject("Error", fields)
+}
+
 // Runs block if expression is nil too
-func execWhile(stmt Statement) (err error) {
+func execWhile(rt *env.Runtime, stmt Statement) (err error) {
 	for {
 		if stmt.Expression != nil {
-			val, err := expr.EvaluateExpression(stmt.Expression)
+			val, err := expr.EvaluateExpression(rt, stmt.Expression)
 			if err != nil {
 				return err
 			}
@@ -352,7 +499,7 @@ func execWhile(stmt Statement) (err error) {
 			}
 		}
 
-		brk, err := loopStatements(stmt.Then.Statements)
+		brk, err := loopStatements(rt, stmt.Then.Statements)
 		if err != nil {
 			return err
 		}
@@ -365,8 +512,8 @@ func execWhile(stmt Statement) (err error) {
 	return err
 }
 
-func execRepeat(stmt Statement) (err error) {
-	v, err := expr.EvaluateExpression(stmt.Expression)
+func execRepeat(rt *env.Runtime, stmt Statement) (err error) {
+	v, err := expr.EvaluateExpression(rt, stmt.Expression)
 	if err != nil {
 		return err
 	}
@@ -378,7 +525,7 @@ func execRepeat(stmt Statement) (err error) {
 
 	// Loop n times
 	for i := 0; i < r; i++ {
-		brk, err := loopStatements(stmt.Then.Statements)
+		brk, err := loopStatements(rt, stmt.Then.Statements)
 		if err != nil {
 			return err
 		}
@@ -391,30 +538,76 @@ func execRepeat(stmt Statement) (err error) {
 	return err
 }
 
-func execObject(stmt Statement) (err error) {
-	err = env.Declare(stmt.Name, &env.Callable{
-		NumArgs: len(stmt.Params),
+func execObject(rt *env.Runtime, stmt Statement) (err error) {
+	def := &env.Definition{Name: stmt.Name, Methods: map[string]*env.Callable{}}
+
+	// Parent fields come first in the constructor
+	if stmt.Expression != nil {
+		value, err := expr.EvaluateExpression(rt, stmt.Expression)
+		if err != nil {
+			return err
+		}
+
+		constructor, ok := value.(*env.Callable)
+		if !ok || constructor.Definition == nil {
+			return env.ErrNotDefinition.At(stmt.Expression.Span, util.GetType(value))
+		}
+
+		def.Parent = constructor.Definition
+		def.Fields = append(def.Fields, def.Parent.Fields...)
+	}
+
+	for _, field := range stmt.Params {
+		if def.Parent != nil {
+			if owner, ok := def.Parent.Defines(field); ok {
+				return env.ErrInherited.With(field, owner)
+			}
+		}
+
+		def.Fields = append(def.Fields, field)
+	}
+
+	// Methods are created once and shared by all instances. They may
+	// override methods, but not fields, of the parent.
+	for _, method := range stmt.Statements {
+		if def.Parent != nil {
+			if owner, ok := def.Parent.Defines(method.Name); ok {
+				if _, _, isMethod := def.Parent.Method(method.Name); !isMethod {
+					return env.ErrInherited.At(method.Span, method.Name, owner)
+				}
+			}
+		}
+
+		def.Methods[method.Name] = newFunction(rt, method)
+	}
+
+	err = rt.Declare(stmt.Name, &env.Callable{
+		Name:       stmt.Name,
+		NumArgs:    len(def.Fields),
+		Definition: def,
 		Call: func(args ...interface{}) (interface{}, error) {
-			obj := env.Object{Fields: map[string]interface{}{}, Name: stmt.Name}
-			for i, field := range stmt.Params {
-				obj.Fields[field] = args[i]
+			fields := map[string]interface{}{}
+			for i, field := range def.Fields {
+				fields[field] = args[i]
 			}
 
-			return &obj, err
+			obj := env.NewObject(stmt.Name, fields)
+			obj.Definition = def
+			return obj, err
 		},
 	})
 
 	return err
 }
 
-func getRangeable(args ...expr.Expression) (v *env.Array, err error) {
+func getRangeable(rt *env.Runtime, args ...expr.Expression) (v *env.Array, err error) {
 	if len(args) > 3 {
 		return v, ErrInvalidStatement
 	}
 
 	// If array just return the array as the list of values to loop over
 	if len(args) == 1 {
-		val, err := expr.EvaluateExpression(&args[0])
+		val, err := expr.EvaluateExpression(rt, &args[0])
 		if err != nil {
 			return v, err
 		}
@@ -422,17 +615,37 @@ func getRangeable(args ...expr.Expression) (v *env.Array, err error) {
 		if arr, ok := val.(*env.Array); ok {
 			return arr, err
 		}
+
+		// Loop over a copy of the keys so the map can be changed in the loop
+		if m, ok := val.(*env.Map); ok {
+			keys := make([]interface{}, len(m.Keys))
+			copy(keys, m.Keys)
+			return env.NewArray(keys), err
+		}
+
+		// Loop over the characters of a string
+		if s, ok := val.(string); ok {
+			chars := []interface{}{}
+			for _, c := range s {
+				chars = append(chars, string(c))
+			}
+
+			return env.NewArray(chars), err
+		}
 	}
 
-	// Else create array of numbers in range
-	a := []float64{}
+	// Else create array of numbers in range. The numbers are integers if
+	// all the arguments are
+	a, ints := []float64{}, true
 	for _, e := range args {
-		val, err := expr.EvaluateExpression(&e)
+		val, err := expr.EvaluateExpression(rt, &e)
 		if err != nil {
 			return v, err
 		}
 
-		if num, ok := val.(float64); ok {
+		if num, ok := env.ToFloat(val); ok {
+			_, isInt := val.(int)
+			ints = ints && isInt
 			a = append(a, num)
 			continue
 		}
@@ -465,33 +678,39 @@ func getRangeable(args ...expr.Expression) (v *env.Array, err error) {
 	// Create array
 	arr := env.Array{Values: []interface{}{}}
 	for i := nums[0]; i < nums[1]; i += nums[2] {
-		arr.Values = append(arr.Values, i)
+		if ints {
+			arr.Values = append(arr.Values, int(i))
+		} else {
+			arr.Values = append(arr.Values, i)
+		}
 	}
 
 	return &arr, err
 }
 
-func execRange(stmt Statement) (err error) {
+func execRange(rt *env.Runtime, stmt Statement) (err error) {
 	var rangeable *env.Array
 	e := stmt.Expression
 	name := stmt.Name
 
 	// Set rangeable
 	if e.Type == expr.Args {
-		rangeable, err = getRangeable(e.Exprs...)
+		rangeable, err = getRangeable(rt, e.Exprs...)
 	} else {
-		rangeable, err = getRangeable(*e)
+		rangeable, err = getRangeable(rt, *e)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	env.PushScope()
-	env.Declare(name, 0.0)
+	// Each iteration has its own scope for the loop variable, so functions made
+	// in the loop keep the value it had in their iteration
 	for _, val := range rangeable.Values {
-		env.Assign(name, val)
-		brk, err := loopStatements(stmt.Then.Statements)
+		rt.PushScope()
+		rt.Declare(name, val)
+		brk, err := loopStatements(rt, stmt.Then.Statements)
+		rt.PopScope()
 		if err != nil {
 			return err
 		}
@@ -501,6 +720,5 @@ func execRange(stmt Statement) (err error) {
 		}
 	}
 
-	env.PopScope()
 	return err
 }
@@ -3,6 +3,7 @@ package stmt
 import (
 	"strings"
 
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/expr"
 	"github.com/jesperkha/Fizz/lexer"
 	"github.com/jesperkha/Fizz/util"
@@ -17,13 +18,13 @@ func ParseStatements(tokens []lexer.Token) (statements []Statement, err error) {
 		firstToken := tokens[currentIdx]
 
 		var currentStmt Statement
-		line := firstToken.Line
+		span := firstToken.Span
 
 		// Check conditional statements seperatly because the parse funcs need
 		// a currentIndex pointer. Note: Full list of tokens is given
 		currentStmt, err = parseComplexStatement(firstToken.Type, tokens, &currentIdx)
 		if err != nil {
-			return statements, util.FormatError(err, line)
+			return statements, diag.Locate(err, span)
 		}
 
 		// Parse any other type of statement.
@@ -31,7 +32,7 @@ func ParseStatements(tokens []lexer.Token) (statements []Statement, err error) {
 			// Seeks a semicolon since all other statements end with a semicolon
 			endIdx, eof := seekToken(tokens, startIndex, lexer.SEMICOLON)
 			if eof {
-				return statements, util.FormatError(ErrNoSemicolon, line)
+				return statements, ErrNoSemicolon.At(span)
 			}
 
 			currentIdx = endIdx // Skip to end of statement to section off token list
@@ -39,17 +40,18 @@ func ParseStatements(tokens []lexer.Token) (statements []Statement, err error) {
 			// Get tokens in interval between last semicolon and current one
 			tokenInterval := tokens[startIndex:currentIdx]
 			if len(tokenInterval) == 0 {
-				return statements, util.FormatError(ErrNoStatement, line)
+				return statements, ErrNoStatement.At(span)
 			}
 
 			// Parse statement
 			currentStmt, err = parseStatement(firstToken.Type, tokenInterval)
 			if err != nil {
-				return statements, util.FormatError(err, line)
+				return statements, diag.Locate(err, util.GetSpan(tokenInterval))
 			}
 		}
 
-		currentStmt.Line = line
+		// Span includes the closing semicolon or brace
+		currentStmt.Span = span.To(tokens[currentIdx].Span)
 		statements = append(statements, currentStmt)
 		currentIdx++
 	}
@@ -66,6 +68,8 @@ func parseStatement(typ int, tokens []lexer.Token) (stmt Statement, err error) {
 		return parsePrint(tokens)
 	case lexer.ELSE:
 		return parseElse(tokens)
+	case lexer.CATCH:
+		return parseCatch(tokens)
 	case lexer.BREAK:
 		return parseBreak(tokens)
 	case lexer.SKIP:
@@ -97,13 +101,18 @@ func parseComplexStatement(typ int, tokens []lexer.Token, idx *int) (stmt Statem
 	case lexer.REPEAT:
 		return parseRepeat(tokens, idx)
 	case lexer.FUNC:
-		return parseFunc(tokens, idx)
+		// Function literals are parsed as expression statements
+		if _, ok := util.SeekFunctionLiteral(tokens, *idx); !ok {
+			return parseFunc(tokens, idx)
+		}
 	case lexer.DEFINE:
 		return parseObject(tokens, idx)
 	case lexer.ENUM:
 		return parseEnum(tokens, idx)
 	case lexer.RANGE:
 		return parseRange(tokens, idx)
+	case lexer.TRY:
+		return parseTry(tokens, idx)
 	}
 
 	return stmt, err
@@ -112,6 +121,12 @@ func parseComplexStatement(typ int, tokens []lexer.Token, idx *int) (stmt Statem
 // Returns index of target
 func seekToken(tokens []lexer.Token, start int, target int) (endIdx int, eof bool) {
 	for i := start; i < len(tokens); i++ {
+		// Skip body of function literals as they have their own statements
+		if end, ok := util.SeekFunctionLiteral(tokens, i); ok {
+			i = end
+			continue
+		}
+
 		// Missing semicolon if there are multiple statement identifiers
 		if i > start && tokens[i].Type >= lexer.FUNC {
 			return 0, true
@@ -261,6 +276,11 @@ func parseElse(tokens []lexer.Token) (stmt Statement, err error) {
 	return stmt, ErrExpectedIf
 }
 
+// Catch blocks are consumed by the try parser so if one is found its an error
+func parseCatch(tokens []lexer.Token) (stmt Statement, err error) {
+	return stmt, ErrExpectedTry
+}
+
 func parsePrint(tokens []lexer.Token) (stmt Statement, err error) {
 	if len(tokens) == 1 {
 		return stmt, ErrExpectedExpression
@@ -296,9 +316,23 @@ func parseAssignment(tokens []lexer.Token) (stmt Statement, err error) {
 		return stmt, err
 	}
 
-	operator := tokens[len(splits[0])].Type
+	// Multiple assignment has a comma separated list of targets. Only variables
+	// can be declared.
+	operator := tokens[len(splits[0])]
+	if left.Type == expr.Args {
+		if operator.Type != lexer.EQUAL && operator.Type != lexer.DEF_EQUAL {
+			return stmt, ErrMultipleOperator.At(operator.Span, operator.Lexeme)
+		}
+
+		for _, target := range left.Exprs {
+			if target.Type != expr.Variable && operator.Type == lexer.DEF_EQUAL {
+				return stmt, ErrExpectedIdentifier.At(target.Span)
+			}
+		}
+	}
+
 	right, err := expr.ParseExpression(splits[1])
-	return Statement{Type: Assignment, Expression: &right, Left: &left, Operator: operator}, err
+	return Statement{Type: Assignment, Expression: &right, Left: &left, Operator: operator.Type}, err
 }
 
 func parseFunc(tokens []lexer.Token, idx *int) (stmt Statement, err error) {
@@ -311,14 +345,30 @@ func parseFunc(tokens []lexer.Token, idx *int) (stmt Statement, err error) {
 		return stmt, ErrInvalidStatement // Missing identifier or block
 	}
 
-	*idx += 3 // Skip to start of param list
+	*idx += 2 // Skip to param list
+	params, block, err := getParamsAndBlock(tokens, idx)
+	return Statement{Type: Function, Name: nameToken.Lexeme, Params: params, Then: &block}, err
+}
+
+// Parses function literal. Set as the expr.ParseLambda hook. Lambdas are the same
+// as function statements, but without a name.
+func parseLambda(tokens []lexer.Token) (lambda interface{}, err error) {
+	idx := 1 // Skip to param list
+	params, block, err := getParamsAndBlock(tokens, &idx)
+	return Statement{Type: Function, Name: "func", Params: params, Then: &block}, err
+}
+
+// Parses param list and function body. Index must be at the left paren of the param list
+// and is moved to the end of the body.
+func getParamsAndBlock(tokens []lexer.Token, idx *int) (params []string, block Statement, err error) {
+	*idx++ // Skip to start of param list
 	endIdx, eof := seekToken(tokens, *idx, lexer.RIGHT_PAREN)
 	if eof {
-		return stmt, ErrInvalidStatement
+		return params, block, ErrInvalidStatement
 	}
 
 	// Get param names
-	params := []string{}
+	params = []string{}
 	for _, p := range tokens[*idx:endIdx] {
 		switch p.Type {
 		case lexer.COMMA:
@@ -328,16 +378,16 @@ func parseFunc(tokens []lexer.Token, idx *int) (stmt Statement, err error) {
 			continue
 		}
 
-		return stmt, ErrExpectedIdentifier
+		return params, block, ErrExpectedIdentifier
 	}
 
 	*idx = endIdx + 1 // Skip to start of block
-	if tokens[*idx].Type != lexer.LEFT_BRACE {
-		return stmt, ErrExpectedBlock
+	if *idx >= len(tokens) || tokens[*idx].Type != lexer.LEFT_BRACE {
+		return params, block, ErrExpectedBlock
 	}
 
-	block, err := getBlockStatement(tokens, idx)
-	return Statement{Type: Function, Name: nameToken.Lexeme, Params: params, Then: &block}, err
+	block, err = getBlockStatement(tokens, idx)
+	return params, block, err
 }
 
 // Modifies index to go to block end. First token must be left brace
@@ -395,6 +445,21 @@ func parseIf(tokens []lexer.Token, idx *int) (stmt Statement, err error) {
 		}
 
 		*idx += 2 // Skip to block
+
+		// Else if is parsed as an else block with a single if statement. The if statement
+		// gets its own span so errors point to the correct branch.
+		if tokens[*idx].Type == lexer.IF {
+			span := tokens[*idx].Span
+			elseIf, err := parseIf(tokens, idx)
+			if err != nil {
+				return stmt, diag.Locate(err, span)
+			}
+
+			elseIf.Span = span.To(tokens[*idx].Span)
+			elseBlock := Statement{Type: Block, Statements: []Statement{elseIf}, Span: elseIf.Span}
+			return Statement{Type: If, Expression: stmt.Expression, Then: &block, Else: &elseBlock}, err
+		}
+
 		elseBlock, err := getBlockStatement(tokens, idx)
 		return Statement{Type: If, Expression: stmt.Expression, Then: &block, Else: &elseBlock}, err
 	}
@@ -402,6 +467,38 @@ func parseIf(tokens []lexer.Token, idx *int) (stmt Statement, err error) {
 	return Statement{Type: If, Expression: stmt.Expression, Then: &block}, err
 }
 
+// Parses try block and the catch block after it. The name of the error
+// variable in the catch block is optional.
+func parseTry(tokens []lexer.Token, idx *int) (stmt Statement, err error) {
+	*idx++ // Skip to block
+	if *idx >= len(tokens) {
+		return stmt, ErrExpectedBlock
+	}
+
+	block, err := getBlockStatement(tokens, idx)
+	if err != nil {
+		return stmt, err
+	}
+
+	if *idx+1 >= len(tokens) || tokens[*idx+1].Type != lexer.CATCH {
+		return stmt, ErrExpectedCatch
+	}
+
+	*idx += 2 // Skip to name or block
+	name := ""
+	if *idx < len(tokens) && tokens[*idx].Type == lexer.IDENTIFIER {
+		name = tokens[*idx].Lexeme
+		*idx++
+	}
+
+	if *idx >= len(tokens) {
+		return stmt, ErrExpectedBlock
+	}
+
+	catchBlock, err := getBlockStatement(tokens, idx)
+	return Statement{Type: Try, Name: name, Then: &block, Else: &catchBlock}, err
+}
+
 func parseWhile(tokens []lexer.Token, idx *int) (stmt Statement, err error) {
 	stmt, block, err := getExpressionAndBlock(tokens, idx, false)
 	if err != nil {
@@ -434,34 +531,84 @@ func parseObject(tokens []lexer.Token, idx *int) (stmt Statement, err error) {
 		return stmt, ErrExpectedIdentifier
 	}
 
-	*idx += 3 // Goto start of block
-	if tokens[*idx-1].Type != lexer.LEFT_BRACE {
+	*idx += 2 // Goto start of block
+
+	// Parent definition to inherit from is put in parens after the name
+	var parent *expr.Expression
+	if tokens[*idx].Type == lexer.LEFT_PAREN {
+		parenIdx, eof := util.SeekClosingBracket(tokens, *idx, lexer.LEFT_PAREN, lexer.RIGHT_PAREN)
+		if eof {
+			return stmt, ErrInvalidStatement
+		}
+
+		if parenIdx == *idx+1 {
+			return stmt, ErrExpectedExpression.At(tokens[*idx].Span.To(tokens[parenIdx].Span))
+		}
+
+		parentExpr, err := expr.ParseExpression(tokens[*idx+1 : parenIdx])
+		if err != nil {
+			return stmt, err
+		}
+
+		parent = &parentExpr
+		*idx = parenIdx + 1
+		if *idx >= len(tokens) {
+			return stmt, ErrExpectedBlock
+		}
+	}
+
+	if tokens[*idx].Type != lexer.LEFT_BRACE {
 		return stmt, ErrExpectedBlock
 	}
 
-	endIdx, eof := seekToken(tokens, *idx, lexer.RIGHT_BRACE)
+	endIdx, eof := util.SeekClosingBracket(tokens, *idx, lexer.LEFT_BRACE, lexer.RIGHT_BRACE)
 	if eof {
 		return stmt, ErrNoBrace
 	}
 
-	fields := tokens[*idx:endIdx]
+	// Fields are names separated by a comma or whitespace. Methods are function
+	// declarations and get the object instance as their first parameter, self,
+	// and the methods of the parent definition as the second, super.
 	fieldNames := []string{}
-	for _, field := range fields {
-		switch field.Type {
+	methods := []Statement{}
+	names := map[string]bool{}
+	for i := *idx + 1; i < endIdx; i++ {
+		token := tokens[i]
+		switch token.Type {
 		case lexer.COMMA:
 			continue
 		case lexer.IDENTIFIER:
-			fieldNames = append(fieldNames, field.Lexeme)
-			continue
+			fieldNames = append(fieldNames, token.Lexeme)
+		case lexer.FUNC:
+			method, err := parseFunc(tokens[:endIdx], &i)
+			if err != nil {
+				return stmt, diag.Locate(err, token.Span)
+			}
+
+			method.Params = append([]string{"self", "super"}, method.Params...)
+			method.Span = token.Span.To(tokens[i].Span)
+			methods = append(methods, method)
+		default:
+			return stmt, ErrExpectedIdentifier.At(token.Span)
 		}
 
-		return stmt, ErrExpectedIdentifier
+		// Fields and methods share names
+		name := token.Lexeme
+		if token.Type == lexer.FUNC {
+			name = methods[len(methods)-1].Name
+		}
+
+		if names[name] {
+			return stmt, ErrDuplicateName.At(token.Span, name)
+		}
+
+		names[name] = true
 	}
 
-	if len(fieldNames) == 0 {
+	if len(fieldNames) == 0 && len(methods) == 0 && parent == nil {
 		return stmt, ErrExpectedIdentifier
 	}
 
 	*idx = endIdx
-	return Statement{Type: Object, Name: nameToken.Lexeme, Params: fieldNames}, err
+	return Statement{Type: Object, Name: nameToken.Lexeme, Params: fieldNames, Statements: methods, Expression: parent}, err
 }
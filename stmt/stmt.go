@@ -3,37 +3,51 @@ package stmt
 import (
 	"errors"
 
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/expr"
 )
 
 var (
-	ErrNoSemicolon        = errors.New("expected ; after statement, line %d")
-	ErrInvalidStmtType    = errors.New("invalid statement type, check statement parsing")
-	ErrExpectedExpression = errors.New("expected expression in statement, line %d")
-	ErrNoStatement        = errors.New("expected statement before semicolon, line %d")
-	ErrExpectedIdentifier = errors.New("expected identifier, line %d")
-	ErrInvalidStatement   = errors.New("invalid statement, line %d")
-	ErrNoBrace            = errors.New("expected } after block statement, line %d")
-	ErrExpectedBlock      = errors.New("expected block after statememt, line %d")
-	ErrExpectedIf         = errors.New("expected if statement before else, line %d")
-	ErrInvalidOperator    = errors.New("invalid statement operator, line %d")
-	ErrDifferentTypes     = errors.New("different types in statement, line %d")
-	ErrNonCallable        = errors.New("cannot call non-callable type, line %d")
-	ErrCommaError         = errors.New("comma error, line %d")
-	ErrNonAssignable      = errors.New("cannot assign value to non-subscriptable, line %d")
-	ErrExpectedName       = errors.New("expected filename at import, line %d")
-	ErrCannotImport       = errors.New("cannot import outside of global scope, line %d")
-	ErrExpectedInteger    = errors.New("expected expression to be integer, line %d")
-	ErrExpectedNumber     = errors.New("expected expression to be number, line %d")
-	ErrInfiniteLoop       = errors.New("infinite loop in range statement not allowed, line %d")
-	ErrMaximumRecursion   = errors.New("maximum recursion depth exceeded, line %d")
+	ErrNoSemicolon        = diag.New(diag.SyntaxError, "E121", "expected ; after statement")
+	ErrExpectedExpression = diag.New(diag.SyntaxError, "E122", "expected expression in statement")
+	ErrNoStatement        = diag.New(diag.SyntaxError, "E123", "expected statement before semicolon")
+	ErrExpectedIdentifier = diag.New(diag.SyntaxError, "E124", "expected identifier")
+	ErrInvalidStatement   = diag.New(diag.SyntaxError, "E125", "invalid statement")
+	ErrNoBrace            = diag.New(diag.SyntaxError, "E126", "expected } after block statement")
+	ErrExpectedBlock      = diag.New(diag.SyntaxError, "E127", "expected block after statememt")
+	ErrExpectedIf         = diag.New(diag.SyntaxError, "E128", "expected if statement before else")
+	ErrCommaError         = diag.New(diag.SyntaxError, "E129", "comma error")
+	ErrExpectedName       = diag.New(diag.SyntaxError, "E130", "expected filename at import")
+	ErrCannotImport       = diag.New(diag.SyntaxError, "E131", "cannot import outside of global scope")
+	ErrDuplicateName      = diag.New(diag.SyntaxError, "E135", "duplicate field or method name '%s'")
+	ErrExpectedCatch      = diag.New(diag.SyntaxError, "E136", "expected catch after try block")
+	ErrExpectedTry        = diag.New(diag.SyntaxError, "E137", "expected try statement before catch")
+	ErrMultipleOperator   = diag.New(diag.SyntaxError, "E138", "cannot use '%s' with multiple assignment")
+	ErrInvalidStmtType    = diag.New(diag.RuntimeError, "E221", "invalid statement type, check statement parsing")
+	ErrInvalidOperator    = diag.New(diag.TypeError, "E222", "invalid statement operator")
+	ErrDifferentTypes     = diag.New(diag.TypeError, "E223", "different types in statement")
+	ErrNonCallable        = diag.New(diag.TypeError, "E224", "cannot call non-callable type")
+	ErrNonAssignable      = diag.New(diag.TypeError, "E225", "cannot assign value to non-subscriptable")
+	ErrExpectedInteger    = diag.New(diag.TypeError, "E226", "expected expression to be integer")
+	ErrExpectedNumber     = diag.New(diag.TypeError, "E227", "expected expression to be number")
+	ErrInfiniteLoop       = diag.New(diag.RuntimeError, "E228", "infinite loop in range statement not allowed")
+	ErrRaised             = diag.New(diag.RuntimeError, "E230", "%s")
+	ErrUnpackCount        = diag.New(diag.RuntimeError, "E231", "expected %d values to unpack, got %d")
+	ErrCannotUnpack       = diag.New(diag.TypeError, "E232", "cannot unpack type %s")
 	ErrProgramExit        = errors.New("")
 
-	ErrReturnOutsideFunc = ConditionalError{Msg: "cannot use return outside of a function, line %d", Type: RETURN}
-	ErrSkipOutsideLoop   = ConditionalError{Msg: "cannot use skip outside of a loop, line %d", Type: SKIP}
-	ErrBeakOutsideLoop   = ConditionalError{Msg: "cannot use break outside of a loop, line %d", Type: BREAK}
+	ErrReturnOutsideFunc = ConditionalError{Err: diag.New(diag.SyntaxError, "E132", "cannot use return outside of a function"), Type: RETURN}
+	ErrSkipOutsideLoop   = ConditionalError{Err: diag.New(diag.SyntaxError, "E133", "cannot use skip outside of a loop"), Type: SKIP}
+	ErrBeakOutsideLoop   = ConditionalError{Err: diag.New(diag.SyntaxError, "E134", "cannot use break outside of a loop"), Type: BREAK}
 )
 
+// Function literals are expressions but contain statements. The expr package
+// cannot import this package, so the parser and evaluator are given to it here.
+func init() {
+	expr.ParseLambda = parseLambda
+	expr.EvalLambda = evalLambda
+}
+
 const (
 	NotStatement = iota
 	ExpressionStmt
@@ -55,11 +69,12 @@ const (
 	Error
 	Enum
 	Range
+	Try
 )
 
+// Statement span covers all tokens of the statement, including its blocks.
 type Statement struct {
 	Type       int
-	Line       int
 	Operator   int
 	Name       string
 	Params     []string
@@ -68,6 +83,7 @@ type Statement struct {
 	Else       *Statement
 	Expression *expr.Expression
 	Left       *expr.Expression
+	diag.Span
 }
 
 const (
@@ -76,13 +92,21 @@ const (
 	RETURN
 )
 
+// Conditional errors are used for control flow and are caught by the loop or
+// function they belong to. If not caught, Err is raised at the span of the
+// statement.
 type ConditionalError struct {
 	Type  int
-	Line  int
-	Msg   string
+	Err   *diag.Error
 	Value interface{}
+	diag.Span
 }
 
 func (c ConditionalError) Error() string {
-	return c.Msg
+	return c.Err.At(c.Span).Error()
+}
+
+// Returns the error to raise when the conditional error was not caught.
+func (c ConditionalError) Unwrap() error {
+	return c.Err.At(c.Span)
 }
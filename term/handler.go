@@ -5,6 +5,7 @@ package term
 type ArgHandler struct {
 	flags   []string
 	options []string
+	values  map[string]string
 	args    []string
 	subcmd  string
 }
@@ -27,6 +28,10 @@ func (a *ArgHandler) HasOption(option string) bool {
 	return false
 }
 
+func (a *ArgHandler) Option(option string) string {
+	return a.values[option]
+}
+
 func (a *ArgHandler) SubCommand() string {
 	return a.subcmd
 }
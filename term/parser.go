@@ -27,6 +27,10 @@ type ArgList interface {
 	// Returns true if option is present (argument starting with '--')
 	HasOption(option string) bool
 
+	// Returns the value of an option given as '--option=value'. The value is
+	// an empty string if the option has no value or is not present.
+	Option(option string) string
+
 	// Returns the name of the subcommand used. The subcommand is the first
 	// string found after the program name, unless it is the only argument,
 	// in which case it will be handled as an argument, not a subommand.
@@ -40,15 +44,20 @@ type ArgList interface {
 // subcommand is found.
 func Parse(valid []string) (list ArgList, err error) {
 	args := os.Args[1:]
-	handler := ArgHandler{}
+	handler := ArgHandler{values: map[string]string{}}
 
 	for idx, arg := range args {
 		if strings.HasPrefix(arg, "--") {
-			// Check if option
-			if !util.SContains(valid, arg) {
+			// Check if option. Options with a value are validated by name
+			split := strings.SplitN(arg, "=", 2)
+			if !util.SContains(valid, split[0]) {
 				return list, fmt.Errorf(ErrUnknownOption.Error(), arg)
 			}
-			handler.options = append(handler.options, strings.TrimLeft(arg, "-"))
+			option := strings.TrimLeft(split[0], "-")
+			handler.options = append(handler.options, option)
+			if len(split) == 2 {
+				handler.values[option] = split[1]
+			}
 		} else if strings.HasPrefix(arg, "-") {
 			// Check if flag (after to avoid false positive)
 			if !util.SContains(valid, arg) {
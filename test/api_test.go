@@ -0,0 +1,157 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jesperkha/Fizz/env"
+	"github.com/jesperkha/Fizz/interp"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestEmbeddingAPI(t *testing.T) {
+	in := interp.New()
+	in.Set("limit", 18)
+	in.Set("users", []user{{"John", 20}, {"Susan", 16}})
+	in.Set("double", func(args ...interface{}) (interface{}, error) {
+		return args[0].(int) * 2, nil
+	})
+
+	code := `
+		adults := [];
+		range u in users {
+			if u.age >= limit { push(adults, u.name); }
+		}
+
+		func greet(name) { return "Hello " + name; }
+		doubled := double(limit);
+	`
+
+	if err := in.Eval(code); err != nil {
+		t.Fatal(err)
+	}
+
+	adults, err := in.Get("adults")
+	if err != nil || !reflect.DeepEqual(adults, []interface{}{"John"}) {
+		t.Errorf("expected [John], got %v, %v", adults, err)
+	}
+
+	if doubled, _ := in.Get("doubled"); doubled != 36 {
+		t.Errorf("expected 36, got %v", doubled)
+	}
+
+	greeting, err := in.CallName("greet", "Carl")
+	if err != nil || greeting != "Hello Carl" {
+		t.Errorf("expected 'Hello Carl', got %v, %v", greeting, err)
+	}
+
+	f, _ := in.Get("greet")
+	if _, err := in.Call(f.(*env.Callable)); err == nil {
+		t.Error("expected error for wrong number of args")
+	}
+
+	if _, err := in.Get("undefined"); err == nil {
+		t.Error("expected error for undefined global")
+	}
+
+	// Integers too large for an int are kept exact
+	in.Set("big", uint64(1<<63))
+	in.Eval("bigger := big * 2;")
+	if bigger, _ := in.Get("bigger"); fmt.Sprint(bigger) != "18446744073709551616" {
+		t.Errorf("expected 18446744073709551616, got %v", bigger)
+	}
+}
+
+func TestErrorValue(t *testing.T) {
+	in := interp.New()
+	err := in.Eval(`define E { code, message } error E(404, "not found");`)
+	if err == nil || err.Error() != "not found, line 1" {
+		t.Fatalf("expected 'not found, line 1', got %v", err)
+	}
+
+	value, ok := interp.ErrorValue(err)
+	expected := map[string]interface{}{"code": 404, "message": "not found"}
+	if !ok || !reflect.DeepEqual(value, expected) {
+		t.Errorf("expected %v, got %v, %v", expected, value, ok)
+	}
+
+	// Maps with a message key use it as the message, like objects
+	err = in.Eval(`error {"code": 404, "message": "not found"};`)
+	if err == nil || err.Error() != "not found, line 1" {
+		t.Errorf("expected 'not found, line 1', got %v", err)
+	}
+
+	in.Eval(`func fail(v) { error v; }`)
+	_, err = in.CallName("fail", []int{1, 2})
+	if value, ok := interp.ErrorValue(err); !ok || !reflect.DeepEqual(value, []interface{}{1, 2}) {
+		t.Errorf("expected [1 2], got %v, %v", value, ok)
+	}
+
+	if _, ok := interp.ErrorValue(in.Eval(`a := 1 / 0;`)); ok {
+		t.Error("expected no value for error not raised by error statement")
+	}
+}
+
+func TestCallFrames(t *testing.T) {
+	in := interp.New()
+	in.SetMaxDepth(10)
+	in.Eval("func f(n) { return n == 0 ? 0 : f(n - 1); }\nfunc g(n) { return 1 / n; }")
+
+	if value, err := in.CallName("f", 9); err != nil || value != 0 {
+		t.Errorf("expected 0, got %v, %v", value, err)
+	}
+
+	// The call from Go counts towards the max depth
+	if _, err := in.CallName("f", 10); !errors.Is(err, env.ErrMaximumRecursion) {
+		t.Errorf("expected max recursion error, got %v", err)
+	}
+
+	if _, err := in.CallName("g", 0); err == nil {
+		t.Fatal("expected error")
+	}
+
+	stack := in.Callstack()
+	if len(stack) != 1 || stack[0].Function != "g" || stack[0].Args[0] != "0" {
+		t.Errorf("expected g(0) on the callstack, got %+v", stack)
+	}
+
+	// The trace of the last error is cleared by the next call
+	in.CallName("f", 1)
+	if stack := in.Callstack(); len(stack) != 0 {
+		t.Errorf("expected empty callstack, got %+v", stack)
+	}
+}
+
+func TestEvalImport(t *testing.T) {
+	dir := t.TempDir()
+	module := filepath.Join(dir, "mod.fizz")
+	if err := os.WriteFile(module, []byte("value := 5;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := interp.New()
+	in.Set("limit", 18)
+	in.Eval("a := 1;")
+	if err := in.Eval(fmt.Sprintf("import %q; b := mod.value + limit;", module)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expect := range map[string]interface{}{"limit": 18, "a": 1, "b": 23} {
+		if value, err := in.Get(name); err != nil || value != expect {
+			t.Errorf("expected %s to be %v, got %v, %v", name, expect, value, err)
+		}
+	}
+
+	// Globals of the importing program are not part of the module
+	if err := in.Eval("c := mod.limit;"); err == nil {
+		t.Error("expected error for global in module")
+	}
+}
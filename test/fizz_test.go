@@ -1,13 +1,21 @@
 package test
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/jesperkha/Fizz/diag"
+	"github.com/jesperkha/Fizz/env"
+	"github.com/jesperkha/Fizz/expr"
 	"github.com/jesperkha/Fizz/interp"
+	"github.com/jesperkha/Fizz/lexer"
+	"github.com/jesperkha/Fizz/lib"
 )
 
 const (
@@ -58,3 +66,452 @@ func TestAll(t *testing.T) {
 		}
 	}
 }
+
+func TestSeparateInterpreters(t *testing.T) {
+	// Each interpreter has its own environment, so declaring the same name
+	// in several of them, even at the same time, should never fail.
+	code := `count := 0; func add() { count += 1; } repeat 100 { add(); } if count != 100 { error "wrong count"; }`
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := interp.New().Interperate("", code)
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent interpreter got error: %s", err)
+		}
+	}
+}
+
+func TestErrorSpans(t *testing.T) {
+	cases := []struct {
+		code string
+		span diag.Span
+	}{
+		{"a := 1;\nb := a + true;", diag.Span{Line: 2, Column: 6, EndLine: 2, EndColumn: 13}},
+		{"x := \"ab\ncd\" + 1;", diag.Span{Line: 1, Column: 6, EndLine: 2, EndColumn: 7}},
+		{"q := 2; q := 3;", diag.Span{Line: 1, Column: 9, EndLine: 1, EndColumn: 15}},
+		{"a := [1][3];", diag.Span{Line: 1, Column: 6, EndLine: 1, EndColumn: 11}},
+		{"x := 1; x.y;", diag.Span{Line: 1, Column: 9, EndLine: 1, EndColumn: 11}},
+		{"x := (1;", diag.Span{Line: 1, Column: 6, EndLine: 1, EndColumn: 7}},
+		{"\n  $", diag.Span{Line: 2, Column: 3, EndLine: 2, EndColumn: 3}},
+		{"print \"a {b}\";", diag.Span{Line: 1, Column: 11, EndLine: 1, EndColumn: 11}},
+		{"print \"a\n  {\n b};\";", diag.Span{Line: 3, Column: 2, EndLine: 3, EndColumn: 2}},
+		{"print \"a {$}\";", diag.Span{Line: 1, Column: 11, EndLine: 1, EndColumn: 11}},
+		{"print \"a\n \\q\";", diag.Span{Line: 2, Column: 2, EndLine: 2, EndColumn: 3}},
+		{"print \"{1} \\x4\";", diag.Span{Line: 1, Column: 12, EndLine: 1, EndColumn: 13}},
+		{"x := `a\n\nb`; y;", diag.Span{Line: 3, Column: 5, EndLine: 3, EndColumn: 5}},
+		{"x := \"ø\" + ø;", diag.Span{Line: 1, Column: 12, EndLine: 1, EndColumn: 12}},
+		{"å := 1; å.b;", diag.Span{Line: 1, Column: 9, EndLine: 1, EndColumn: 11}},
+		{"print \"ø\";\n ø😀;", diag.Span{Line: 2, Column: 3, EndLine: 2, EndColumn: 3}},
+		{"if false {\n} else if 1 + true {\n}", diag.Span{Line: 2, Column: 11, EndLine: 2, EndColumn: 18}},
+		{"if false {\n} else if false {\n} else if true {\n    a;\n}", diag.Span{Line: 4, Column: 5, EndLine: 4, EndColumn: 5}},
+		{"if false {\n} else if true {\n    error \"e\";\n}", diag.Span{Line: 3, Column: 5, EndLine: 3, EndColumn: 14}},
+		{"if true {\n} else if {\n}", diag.Span{Line: 2, Column: 8, EndLine: 2, EndColumn: 9}},
+		{"x := 1 + \"øø\";", diag.Span{Line: 1, Column: 6, EndLine: 1, EndColumn: 13}},
+		{"x := 1 + `a\nøø`;", diag.Span{Line: 1, Column: 6, EndLine: 2, EndColumn: 3}},
+	}
+
+	for _, c := range cases {
+		_, err := interp.Interperate("", c.code)
+		var e *diag.Error
+		if !errors.As(err, &e) {
+			t.Errorf("%q: expected error with span, got %v", c.code, err)
+			continue
+		}
+
+		if e.Span != c.span {
+			t.Errorf("%q: expected span %+v, got %+v", c.code, c.span, e.Span)
+		}
+	}
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	in := interp.New()
+	_, err := in.Interperate("main.fizz", "length := 1;\nprint lenght + 1;")
+
+	var e *diag.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected diagnostic error, got %v", err)
+	}
+
+	expect := strings.Join([]string{
+		"error[E242]: undefined variable 'lenght'",
+		" --> main.fizz:2:7",
+		"  |",
+		"2 | print lenght + 1;",
+		"  |       ^^^^^^",
+		"  = hint: did you mean 'length'?",
+	}, "\n")
+
+	if out := diag.Render(e, in.Source(e.File)); out != expect {
+		t.Errorf("expected:\n%s\ngot:\n%s", expect, out)
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	cases := []struct {
+		code   string
+		target *diag.Error
+		kind   diag.Kind
+	}{
+		{"print 1 / 0;", expr.ErrDivideByZero, diag.RuntimeError},
+		{"print a;", env.ErrUndefined, diag.NameError},
+		{"include \"nope\";", lib.ErrNotLib, diag.ImportError},
+	}
+
+	for _, c := range cases {
+		_, err := interp.Interperate("", c.code)
+		if !errors.Is(err, c.target) {
+			t.Errorf("%q: expected %s, got %v", c.code, c.target.Code, err)
+			continue
+		}
+
+		var e *diag.Error
+		errors.As(err, &e)
+		if e.Kind != c.kind || e.Span.Line != 1 {
+			t.Errorf("%q: expected %s on line 1, got %s on line %d", c.code, c.kind, e.Kind, e.Span.Line)
+		}
+	}
+}
+
+// Braces in strings start embedded expressions, so text that used to be
+// plain, like JSON, now needs escaped braces or a raw string.
+func TestStringBraces(t *testing.T) {
+	for _, code := range []string{"print \"{a\";", "print \"{\\\"a\\\": 1}\";"} {
+		_, err := interp.Interperate("", code)
+		var e *diag.Error
+		if !errors.Is(err, lexer.ErrUnterminatedString) || !errors.As(err, &e) {
+			t.Errorf("%q: expected %s, got %v", code, lexer.ErrUnterminatedString.Code, err)
+			continue
+		}
+
+		if !strings.Contains(e.Hint, "\\{") {
+			t.Errorf("%q: expected hint about \\{, got %q", code, e.Hint)
+		}
+	}
+}
+
+func TestJSONError(t *testing.T) {
+	in := interp.New()
+	_, err := in.Interperate("main.fizz", "func f(a) {\n    return a / b;\n}\n\nf(1);")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	expect := `{"file":"main.fizz","line":2,"column":16,"endLine":2,"endColumn":16,` +
+		`"code":"E242","kind":"name","message":"undefined variable 'b'",` +
+		`"stack":[{"function":"f","file":"main.fizz","line":5,"column":1,"args":["1"]}]}`
+
+	if out := string(diag.JSON(err, in.Callstack())); out != expect {
+		t.Errorf("expected:\n%s\ngot:\n%s", expect, out)
+	}
+}
+
+func TestCallstack(t *testing.T) {
+	code := strings.Join([]string{
+		"func inner(a, b) {",
+		"    return len(a);",
+		"}",
+		"func outer() {",
+		"    x := 1 + inner(2, \"s\");",
+		"}",
+		"outer();",
+	}, "\n")
+
+	in := interp.New()
+	if _, err := in.Interperate("main.fizz", code); err == nil {
+		t.Fatal("expected error")
+	}
+
+	expect := strings.Join([]string{
+		"\tat len(2) in main.fizz:2:12",
+		"\tat inner(2, \"s\") in main.fizz:5:14",
+		"\tat outer() in main.fizz:7:1",
+	}, "\n")
+
+	if out := in.GetCallstack(); out != expect {
+		t.Errorf("expected:\n%s\ngot:\n%s", expect, out)
+	}
+
+	// Callstack is cleared on the next run
+	if in.Interperate("main.fizz", "outer := 1;"); in.GetCallstack() != "" {
+		t.Errorf("expected empty callstack, got %s", in.GetCallstack())
+	}
+
+	// Arguments are printed as they are, even with format verbs in them
+	in.Interperate("main.fizz", "func f(s) { error s; }\nf(\"100% done\");")
+	if out, expect := in.GetCallstack(), "\tat f(\"100% done\") in main.fizz:2:1"; out != expect {
+		t.Errorf("expected %q, got %q", expect, out)
+	}
+
+	// The full callstack is shown, and matches the frames in the JSON output
+	in.Interperate("main.fizz", "func f(n) { if n == 0 { error \"e\"; } f(n - 1); }\nf(20);")
+	if lines := strings.Split(in.GetCallstack(), "\n"); len(lines) != 21 || len(lines) != len(in.Callstack()) {
+		t.Errorf("expected 21 frames, got %d", len(lines))
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	cases := []struct {
+		code  string
+		chain string
+	}{
+		{"func f(n) { return f(n + 1); }\nf(0);", "f -> f"},
+		{"func a() { return b(); }\nfunc b() { return a(); }\na();", "a -> b -> a"},
+	}
+
+	for _, c := range cases {
+		in := interp.New()
+		in.SetMaxDepth(50)
+		_, err := in.Interperate("", c.code)
+
+		expect := env.ErrMaximumRecursion.With(50, c.chain).Msg
+		var e *diag.Error
+		if !errors.As(err, &e) || e.Msg != expect {
+			t.Errorf("%q: expected %q, got %v", c.code, expect, err)
+		}
+	}
+
+	// Depth is measured from the callstack, so unrelated calls do not add up
+	in := interp.New()
+	in.SetMaxDepth(3)
+	if _, err := in.Interperate("", "func f() {}\nwhile true { f(); f(); f(); f(); break; }"); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+}
+
+// Runs each case and checks the value of the global variable x after.
+func TestValues(t *testing.T) {
+	cases := []struct {
+		code   string
+		expect interface{}
+	}{
+		// Function literals
+		{"f := func(a, b) { return a + b; }; x := f(1, 2);", 3},
+		{"x := func(n) { return n * 2; }(21);", 42},
+		{"fs := []; range i in 3 { push(fs, func() { return i; }); } x := [fs[0]() + fs[1]() + fs[2](), fs[2]()];", []interface{}{3, 2}},
+		{"n := 0; fs := []; range i in 3 { push(fs, func() { n += i; return n; }); } fs[1](); x := fs[2]();", 3},
+		{"func counter() { n := 0; return func() { n += 1; return n; }; } c := counter(); c(); x := c();", 2},
+		{"f := func(n) { if n == 0 { return 0; } return n + f(n - 1); }; x := f(4);", 10},
+		{"func apply(arr, f) { out := []; range v in arr { push(out, f(v)); } return out; } x := apply([1, 2], func(v) { return v + 1; });", []interface{}{2, 3}},
+
+		// Else if chains
+		{"x := 0; a := 2; if a == 1 { x = 1; } else if a == 2 { x = 2; } else { x = 3; }", 2},
+		{"x := 0; a := 5; if a == 1 { x = 1; } else if a == 2 { x = 2; } else if a == 3 { x = 3; } else { x = 4; }", 4},
+		{"x := 0; if false { x = 1; } else if false { x = 2; }", 0},
+
+		// Maps
+		{"m := {\"a\": 1}; m[\"b\"] = 2; x := m[\"a\"] + m[\"b\"];", 3},
+		{"m := {\"a\": 1, \"b\": 2}; m.delete(\"a\"); x := [len(m), \"a\" in m, \"b\" in m];", []interface{}{1, false, true}},
+		{"m := {\"b\": 1, \"a\": 2}; x := []; range k in m { push(x, k); }", []interface{}{"b", "a"}},
+		{"x := {1: 2, true: \"t\"} == {true: \"t\", 1: 2};", true},
+		{"c := {}; range w in [\"a\", \"b\", \"a\"] { if w in c { c[w] += 1; } else { c[w] = 1; } } x := c[\"a\"];", 2},
+		{"x := {\"f\": func(a) { return a * 2; }}[\"f\"](2);", 4},
+
+		// Methods
+		{"define P { n func get() { return self.n; } } x := P(3).get();", 3},
+		{"define P { n func add(a) { self.n += a; return self; } } p := P(1); p.add(2).add(3); x := p.n;", 6},
+		{"define P { n func get() { return self.n; } } f := P(4).get; x := f();", 4},
+		{"define P { func name() { return \"p\"; } } x := P().name();", "p"},
+		{"define P { n func same(o) { return self.n == o.n; } } x := P(1).same(P(1));", true},
+
+		// Inheritance
+		{"define A { a func f() { return self.a; } } define B(A) { b } x := B(1, 2).f();", 1},
+		{"define A { a func f() { return 1; } } define B(A) { func f() { return 2; } } x := B(0).f();", 2},
+		{"define A { func f() { return 1; } } define B(A) { func f() { return super.f() + 1; } } x := B().f();", 2},
+		{"define A { func f() { return self.g(); } func g() { return 1; } } define B(A) { func g() { return 2; } } x := B().f();", 2},
+		{"define A { a } define B(A) { b } x := B(1, 2).b;", 2},
+		{"define A { a } define B(A) { b } x := B(1, 2) is A;", true},
+		{"define A { a } define B(A) { b } x := A(1) is B;", false},
+		{"define A { a } x := 1 is A;", false},
+		{"define A { func f() { return super; } } x := A().f();", nil},
+		{"define A { n func f() { return self.n; } } define B(A) { func f() { return super.f() + 10; } } define C(B) { func f() { return super.f() + 100; } } c := C(1); x := [c.f(), c.f(), B(2).f()];", []interface{}{111, 111, 12}},
+
+		// Try and catch
+		{"x := 0; try { error \"e\"; x = 1; } catch { x = 2; }", 2},
+		{"x := 0; try { x = 1; } catch { x = 2; }", 1},
+		{"try { error \"e\"; } catch err { x := err.message; }", nil},
+		{"x := nil; try { error \"e\"; } catch err { x = err.message; }", "e"},
+		{"x := nil; try { a := 1 / 0; } catch err { x = err.code; }", "E204"},
+		{"x := nil; try {\n  a := b;\n} catch err { x = err.line; }", 2},
+		{"func f() { error \"e\"; } x := nil; try { f(); } catch err { x = err.message; }", "e"},
+		{"func f() { try { return 1; } catch { return 2; } } x := f();", 1},
+		{"x := 0; while true { x += 1; try { if x == 3 { break; } skip; } catch { x = 100; } }", 3},
+		{"x := 0; range i in 5 { try { error \"e\"; } catch { x += 1; } }", 5},
+		{"func f(n) { return f(n + 1); } x := nil; try { f(0); } catch err { x = err.code; }", "E248"},
+
+		// Error values
+		{"x := nil; try { error [1, 2]; } catch err { x = err.value[1]; }", 2},
+		{"define E { code } x := nil; try { error E(3); } catch err { x = err.value.code; }", 3},
+		{"define E { message } x := nil; try { error E(\"m\"); } catch err { x = err.message; }", "m"},
+		{"x := 0; try { error nil; } catch err { x = err.value; }", nil},
+		{"x := 0; try { a := 1 / 0; } catch err { x = err.value; }", nil},
+		{"func f() { error {\"a\": 1}; } x := nil; try { f(); } catch err { x = err.value[\"a\"]; }", 1},
+		{"x := nil; try { error {\"message\": \"m\", \"code\": 1}; } catch err { x = [err.message, err.value[\"code\"]]; }", []interface{}{"m", 1}},
+
+		// Short-circuit logical operators
+		{"x := 1 & 2;", 2},
+		{"x := nil & 2;", nil},
+		{"x := false : \"default\";", "default"},
+		{"x := 0 : 1;", 0},
+		{"a := nil; x := a != nil & a.name == \"b\";", false},
+		{"x := 0; func f() { x = 1; return true; } true : f();", 0},
+		{"x := 0; func f() { x = 1; return true; } false & f();", 0},
+		{"x := 0; func f() { x = 1; return true; } true & f();", 1},
+		{"x := nil : false : 3;", 3},
+
+		// Conditional expressions
+		{"x := 1 > 0 ? \"a\" : \"b\";", "a"},
+		{"x := nil ? \"a\" : \"b\";", "b"},
+		{"a := 5; x := a > 10 ? 1 : a > 3 ? 2 : 3;", 2},
+		{"a := 5; x := a > 3 ? a > 10 ? 1 : 2 : 3;", 2},
+		{"x := [true ? 1 : 2, 3][0];", 1},
+		{"func f(a) { return a; } x := f(false ? 1 : 2);", 2},
+		{"x := false ? 1 : nil : 3;", 3},
+		{"x := 0; func f() { x = 1; } true ? 2 : f();", 0},
+		{"x := 0; func f() { x = 1; } false ? f() : 2;", 0},
+
+		// Safe getter and nil coalescing
+		{"define P { a } x := P(nil)?.a;", nil},
+		{"a := nil; x := a?.b;", nil},
+		{"a := nil; x := a?.b?.c;", nil},
+		{"define P { a } x := P(P(2))?.a?.a;", 2},
+		{"x := nil ?? 1;", 1},
+		{"x := false ?? 1;", false},
+		{"x := nil ?? nil ?? 2;", 2},
+		{"a := nil; x := a?.b ?? \"none\";", "none"},
+		{"x := 0; func f() { x = 1; } 1 ?? f();", 0},
+
+		// Slicing and negative indices
+		{"x := [1, 2, 3][-1];", 3},
+		{"a := [1, 2, 3]; a[-1] = 4; x := a[2];", 4},
+		{"x := \"abc\"[-1];", "c"},
+		{"x := [0, 1, 2, 3][1:3];", []interface{}{1, 2}},
+		{"x := [0, 1, 2, 3][:2];", []interface{}{0, 1}},
+		{"x := [0, 1, 2, 3][2:];", []interface{}{2, 3}},
+		{"x := [0, 1, 2, 3][-3:-1];", []interface{}{1, 2}},
+		{"x := [0, 1, 2, 3][::2];", []interface{}{0, 2}},
+		{"x := [0, 1, 2, 3][::-1];", []interface{}{3, 2, 1, 0}},
+		{"x := [0, 1, 2, 3][3:0:-2];", []interface{}{3, 1}},
+		{"x := [0, 1, 2, 3][1:100];", []interface{}{1, 2, 3}},
+		{"x := [0, 1, 2, 3][3:1];", []interface{}{}},
+		{"x := \"hello\"[1:3];", "el"},
+		{"x := \"hello\"[::-1];", "olleh"},
+		{"a := [1, 2]; b := a[:]; b[0] = 3; x := a[0];", 1},
+		{"x := [1, 2, 3][true ? 1 : 2];", 2},
+
+		// Multiple assignment
+		{"a, b := 1, 2; x := [a, b];", []interface{}{1, 2}},
+		{"a, b := 1, 2; a, b = b, a; x := [a, b];", []interface{}{2, 1}},
+		{"func f() { return [1, 2]; } a, b := f(); x := b;", 2},
+		{"a, b := [1, 2]; a, b = [b, a]; x := a;", 2},
+		{"define P { name, age } name, age := P(\"a\", 1); x := age;", 1},
+		{"x := [0, 0]; x[0], x[1] = 1, 2;", []interface{}{1, 2}},
+		{"x := nil; try { a, b := [1]; } catch err { x = err.code; }", "E231"},
+		{"x := nil; try { a, b := 1; } catch err { x = err.code; }", "E232"},
+
+		// String interpolation
+		{"a := 1; x := \"a is {a}\";", "a is 1"},
+		{"x := \"{1 + 2}{[1]}\";", "3[1]"},
+		{"define P { name } p := P(\"b\"); x := \"name: {p.name}!\";", "name: b!"},
+		{"x := \"{\"inner {1}\"}\";", "inner 1"},
+		{"m := {\"k\": 2}; x := \"{m[\"k\"]}\";", "2"},
+		{"x := \"{} { } \\{a\\}\";", "{} { } {a}"},
+		{"x := \"{true ? \"y\" : \"n\"}\";", "y"},
+		{"x := \"a\n{1}\";", "a\n1"},
+
+		// Escapes and raw strings
+		{"x := \"\\\"a\\\"\";", "\"a\""},
+		{"x := \"a\\\\b\\r\\0\";", "a\\b\r\x00"},
+		{"x := \"\\x41\\u{e9}\\u{1F600}\";", "A\u00e9\U0001F600"},
+		{"x := \"{\"\\\"\"}\\\"\";", "\"\""},
+		{"x := `a\\n{b}\n\"c\"`;", "a\\n{b}\n\"c\""},
+
+		// Unicode strings and identifiers
+		{"x := len(\"blåbær\");", 6},
+		{"x := \"blåbær\"[2];", "å"},
+		{"x := \"a😀b\"[-2];", "😀"},
+		{"x := \"blåbær\"[::-1];", "ræbålb"},
+		{"x := \"æøå\"[1:];", "øå"},
+		{"x := \"\"; range c in \"hé😀\" { x += c + \",\"; }", "h,é,😀,"},
+		{"größe := 2; x := größe;", 2},
+
+		// Integers and number literals
+		{"x := [0xFF, 0o17, 0b101, 1_000_000];", []interface{}{255, 15, 5, 1000000}},
+		{"x := [7 / 2, 6 / 2, 7 % 3, 2 ^ 3];", []interface{}{3.5, 3, 1, 8}},
+		{"x := [0.5 + 0.5, 1 + 1.5, 10 % 4.5];", []interface{}{1.0, 2.5, 1.0}},
+		{"x := \"{9223372036854775807 + 1}\";", "9223372036854775808"},
+		{"x := \"{2 ^ 64}\";", "18446744073709551616"},
+		{"x := \"{-0xFFFFFFFFFFFFFFFFFF}\";", "-4722366482869645213695"},
+		{"x := 2 ^ 100 - 2 ^ 100 + 1;", 1},
+		{"x := (2 ^ 100 + 1) / 2 ^ 99;", 2.0},
+		{"x := [2 ^ 64 > 1.5, 2 ^ 64 == 2 ^ 64, 1 == 1.0, type (2 ^ 64)];", []interface{}{true, true, true, "number"}},
+		{"x := 5; x /= 2;", 2.5},
+		{"x := {1: \"a\"}[1.0];", "a"},
+		{"x := [1, 2, 3][2.0];", 3},
+		{"m := {2 ^ 70: \"a\"}; x := [m[2 ^ 70], 2 ^ 70 in m, 2 ^ 71 in m];", []interface{}{"a", true, false}},
+		{"m := {100000000000000000000: \"a\"}; x := [m[1e20], len(m)]; m[1e20] = \"b\"; x = [m[10 ^ 20], len(m)];", []interface{}{"b", 1}},
+		{"m := {1e19: \"a\", 2e19: \"b\", 0: \"c\"}; x := [m[1e19], m[2e19], m[0], len(m)];", []interface{}{"a", "b", "c", 3}},
+		{"m := {\"1180591620717411303424\": 1}; x := 2 ^ 70 in m;", false},
+		{"m := {2 ^ 70: 1, 1.5: 2}; m.delete(2 ^ 70); x := \"{m}\";", "{1.5: 2}"},
+		{"m := {\"1\": \"a\", 1: \"b\", true: 2}; x := \"{m}\";", "{\"1\": a, 1: b, true: 2}"},
+		{"func delete(a, b) { return a + b; } m := {\"delete\": 1}; d := m.delete; d(\"delete\"); x := [delete(1, 2), len(m)];", []interface{}{3, 0}},
+		{"x := []; range i in 0, 1, 0.5 { push(x, i); }", []interface{}{0.0, 0.5}},
+		{"include \"str\"; x := str.toNumber(\"42\") // 2;", 21},
+		{"include \"str\"; x := [str.toNumber(\"-7\"), str.toNumber(\"0xFF\"), str.toNumber(\"1.5\"), str.toNumber(\"-2.5\")];", []interface{}{-7, 255, 1.5, -2.5}},
+		{"include \"str\"; x := \"{str.toNumber(\"123456789012345678901234567890\")}\";", "123456789012345678901234567890"},
+		{"include \"str\"; x := nil; try { str.toNumber(\"1x\"); } catch err { x = err.code; }", "E421"},
+		{"include \"math\"; x := [math.floor(2.7), math.ceil(2.1), math.floor(-2.5), math.floor(7 / 2) // 2];", []interface{}{2, 3, -3, 1}},
+		{"include \"math\"; x := \"{math.floor(1e20)}\";", "100000000000000000000"},
+
+		// Bitwise operators and floor division
+		{"x := [6 bitand 3, 6 bitor 3, 6 bitxor 3, bitnot 5];", []interface{}{2, 7, 5, -6}},
+		{"x := [1 << 10, 1024 >> 3, -16 >> 2];", []interface{}{1024, 128, -4}},
+		{"x := [7 // 2, -7 // 2, 7 // -2, 10 // 5];", []interface{}{3, -4, -4, 2}},
+		{"x := \"{1 << 100}\";", "1267650600228229401496703205376"},
+		{"c := true; x := [c ?.5 : 1, c ? .5 : 1, .25 + 1, -.5];", []interface{}{0.5, 0.5, 1.25, -0.5}},
+		{"define P { a } p := P(1); n := nil; x := [p?.a, n?.a, 7 // 2, \"http://a//b\"];", []interface{}{1, nil, 3, "http://a//b"}},
+		{"x := \"\\{\\\"a\\\": 1\\}\";", "{\"a\": 1}"},
+		{"x := `{\"a\": {}}`;", "{\"a\": {}}"},
+		{"a := 1; x := \"{} \\{a} {a} a}\";", "{} {a} 1 a}"},
+		{"x := [10 * 3 % 4, (10 * 3) % 4, 10 % 4 * 3, 2 * 7 // 2, 12 / 2 * 3, 13 // 2 % 4];", []interface{}{30, 2, 6, 7, 18, 6}},
+		{"x := [8 - 3 - 2, 10 - 2 + 3, 1 - -1, 2 ^ -1];", []interface{}{3, 11, 2, 0.5}},
+		{"a := 5; x := [a * -1, a - -a, a // -2];", []interface{}{-5, 10, -3}},
+		{"x := [(1 << 100) >> 99, \"{(2 ^ 70) bitand (2 ^ 70 + 5)}\"];", []interface{}{2, "1180591620717411303424"}},
+		{"x := 0xFF00 >> 8 bitand 0x0F;", 15},
+		{"x := 3 bitor 4 == 7;", true},
+		{"x := 1 << 2 + 1;", 8},
+
+		// Operators with the same precedence are evaluated left to right
+		{"n := 1234; x := [(n // 10) % 10, n % 100 // 10, 7 * 3 // 2];", []interface{}{3, 3, 10}},
+		{"x := [7 * 5 % 3, (7 * 5) % 3, 10 - 2 - 3, 1 - 2 + 3, 8 / 4 * 2];", []interface{}{14, 2, 5, 2, 4}},
+		{"x := [1 + -1, 2 * -3, 2 ^ -1];", []interface{}{0, -6, 0.5}},
+		{"x := nil; try { 1.5 bitand 1; } catch err { x = err.code; }", "E213"},
+		{"x := nil; try { 1 << -1; } catch err { x = err.code; }", "E214"},
+
+		// Function scope does not leak after nested calls
+		{"y := 1; func b() {} func a(y) { b(); } a(5); x := y;", 1},
+	}
+
+	for _, c := range cases {
+		in := interp.New()
+		if err := in.Eval(c.code); err != nil {
+			t.Errorf("%q: got error: %s", c.code, err)
+			continue
+		}
+
+		if x, _ := in.Get("x"); !reflect.DeepEqual(x, c.expect) {
+			t.Errorf("%q: expected %v, got %v", c.code, c.expect, x)
+		}
+	}
+}
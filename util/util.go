@@ -1,34 +1,31 @@
 package util
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
-	"reflect"
 	"strings"
 
 	ct "github.com/daviddengcn/go-colortext"
+	"github.com/jesperkha/Fizz/diag"
 	"github.com/jesperkha/Fizz/env"
 	"github.com/jesperkha/Fizz/lexer"
 )
 
-// Format error with line numbers for local errors, but ignore for errors passed from
-// expression parsing as they are already formatted with line numbers.
-func FormatError(err error, line int) error {
-	if err == nil {
-		return err
+// Returns span going from the first to the last token in the list
+func GetSpan(tokens []lexer.Token) diag.Span {
+	if len(tokens) == 0 {
+		return diag.Span{}
 	}
 
-	if strings.Contains(err.Error(), "%d") {
-		return fmt.Errorf(err.Error(), line)
-	}
-
-	return err
+	return tokens[0].Span.To(tokens[len(tokens)-1].Span)
 }
 
 // Converts value to string in proper representation format
 func FormatPrintValue(val interface{}) string {
 	switch val.(type) {
-	case float64, string, bool:
+	case int, *big.Int, float64, string, bool:
 		return fmt.Sprint(val)
 	case nil:
 		return "nil"
@@ -57,6 +54,26 @@ func FormatPrintValue(val interface{}) string {
 		return o.Name + "()"
 	}
 
+	if m, ok := val.(*env.Map); ok {
+		str := "{"
+		for i, k := range m.Keys {
+			if i != 0 {
+				str += ", "
+			}
+
+			// String keys are quoted so they cannot be confused with other keys
+			key := FormatPrintValue(k)
+			if s, ok := k.(string); ok {
+				key = fmt.Sprintf("%q", s)
+			}
+
+			v, _ := m.Get(k)
+			str += fmt.Sprintf("%s: %s", key, FormatPrintValue(v))
+		}
+
+		return str + "}"
+	}
+
 	if a, ok := val.(*env.Array); ok {
 		str := "["
 		for i, v := range a.Values {
@@ -84,6 +101,30 @@ func PrintError(err error) {
 	ct.ResetColor()
 }
 
+// Prints error as a diagnostic with the source line and a marker under the
+// span of the error. The source function returns the source code for the given
+// filename. Errors without a span are printed with PrintError.
+func PrintDiagnostic(err error, source func(filename string) string) {
+	var d *diag.Error
+	if !errors.As(err, &d) || d.Span.Line == 0 {
+		PrintError(err)
+		return
+	}
+
+	lines := strings.SplitN(diag.Render(d, source(d.File)), "\n", 2)
+	ct.Foreground(ct.Red, true)
+	fmt.Fprintln(os.Stderr, lines[0])
+	ct.ResetColor()
+	if len(lines) > 1 {
+		fmt.Fprintln(os.Stderr, lines[1])
+	}
+}
+
+// Prints error and callstack to stderr as a single line of JSON. See diag.JSON.
+func PrintJSONError(err error, stack []diag.Frame) {
+	fmt.Fprintln(os.Stderr, string(diag.JSON(err, stack)))
+}
+
 // Prints error followed by program exit. Exit code 1 is reserved for crashes
 func ErrorAndExit(err error) {
 	PrintError(err)
@@ -136,7 +177,23 @@ func SeekClosingBracket(tokens []lexer.Token, start int, beginT, endT int) (endI
 	return endIdx, true
 }
 
-// Skips token check if in group or array expression. Returns index of ending token or eof.
+// Returns index of the closing brace of the function literal starting at start,
+// or false if there is no function literal there: func (params) { body }
+func SeekFunctionLiteral(tokens []lexer.Token, start int) (endIdx int, ok bool) {
+	if start+1 >= len(tokens) || tokens[start].Type != lexer.FUNC || tokens[start+1].Type != lexer.LEFT_PAREN {
+		return endIdx, false
+	}
+
+	paramEnd, eof := SeekClosingBracket(tokens, start+1, lexer.LEFT_PAREN, lexer.RIGHT_PAREN)
+	if eof || paramEnd+1 >= len(tokens) || tokens[paramEnd+1].Type != lexer.LEFT_BRACE {
+		return endIdx, false
+	}
+
+	endIdx, eof = SeekClosingBracket(tokens, paramEnd+1, lexer.LEFT_BRACE, lexer.RIGHT_BRACE)
+	return endIdx, !eof
+}
+
+// Skips token check if in group, array expression, or function body. Returns index of ending token or eof.
 func SeekBreakPoint(tokens []lexer.Token, verifier func(int, lexer.Token) bool) (targetIdx int, eof bool) {
 	parens := 0
 	targetIdx = -1
@@ -147,9 +204,9 @@ func SeekBreakPoint(tokens []lexer.Token, verifier func(int, lexer.Token) bool)
 		}
 
 		switch token.Type {
-		case lexer.LEFT_PAREN, lexer.LEFT_SQUARE:
+		case lexer.LEFT_PAREN, lexer.LEFT_SQUARE, lexer.LEFT_BRACE:
 			parens++
-		case lexer.RIGHT_PAREN, lexer.RIGHT_SQUARE:
+		case lexer.RIGHT_PAREN, lexer.RIGHT_SQUARE, lexer.RIGHT_BRACE:
 			parens--
 		}
 	}
@@ -169,9 +226,9 @@ func SplitByTokens(tokens []lexer.Token, splits []int) [][]lexer.Token {
 	result := [][]lexer.Token{}
 	for idx, token := range tokens {
 		switch token.Type {
-		case lexer.LEFT_PAREN, lexer.LEFT_SQUARE:
+		case lexer.LEFT_PAREN, lexer.LEFT_SQUARE, lexer.LEFT_BRACE:
 			numParen++
-		case lexer.RIGHT_PAREN, lexer.RIGHT_SQUARE:
+		case lexer.RIGHT_PAREN, lexer.RIGHT_SQUARE, lexer.RIGHT_BRACE:
 			numParen--
 		}
 
@@ -191,18 +248,7 @@ func SplitByTokens(tokens []lexer.Token, splits []int) [][]lexer.Token {
 
 // Returns Fizz name for value
 func GetType(value interface{}) string {
-	if i, ok := value.(env.FizzObject); ok {
-		return (i).Type()
-	}
-
-	switch value.(type) {
-	case float64, float32, int:
-		return "number"
-	case nil:
-		return "nil"
-	}
-
-	return reflect.TypeOf(value).Name()
+	return env.TypeOf(value)
 }
 
 // Gets fizz type name from reflect name
@@ -214,19 +260,24 @@ func GetLibType(typ string) string {
 		return "function"
 	case "Array":
 		return "array"
+	case "Map":
+		return "map"
 	}
 
 	return typ
 }
 
-// Adds filename to error message if not already done. Returns nil if err is nil.
+// Returns a copy of err with the file set, if err is a *diag.Error without one.
+// Other errors have no file to set and are returned as they are.
 func WrapFilename(filename string, err error) error {
-	if err == nil || err.Error() == "" {
+	if err == nil || err.Error() == "" || filename == "" {
 		return err
 	}
 
-	if !strings.Contains(err.Error(), ".fizz") {
-		err = fmt.Errorf("%s: %s", filename, err.Error())
+	if d, ok := err.(*diag.Error); ok && d.File == "" {
+		c := *d
+		c.File = filename
+		return &c
 	}
 
 	return err
@@ -254,7 +305,13 @@ func GetPlainFilename(path string) string {
 	return split[len(split)-1]
 }
 
+// Returns the value as an int if it is an integer, or a float with an integer
+// value. Big integers do not fit in an int and return false.
 func IsInt(value interface{}) (int, bool) {
+	if v, ok := value.(int); ok {
+		return v, true
+	}
+
 	if v, ok := value.(float64); ok {
 		iv := int(v)
 		return iv, v == float64(iv)